@@ -0,0 +1,201 @@
+package eventloop
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrStreamClosed = errors.New("eventloop: stream closed")
+
+// latest returns a channel holding only the most recent value passed to send,
+// so send never blocks. The channel is closed once p settles, and later sends
+// are ignored.
+func latest[T any](p *Promise) (send func(T), values <-chan T) {
+	ch := make(chan T, 1)
+	var mu sync.Mutex
+	closed := false
+	go func() {
+		<-p.settled
+		mu.Lock()
+		closed = true
+		close(ch)
+		mu.Unlock()
+	}()
+	return func(v T) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		// replace a value the consumer has not read yet
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- v
+	}, ch
+}
+
+// AsyncProgress is Async with a progress reporter. fn calls report to publish
+// progress on the returned channel. The channel holds only the latest value,
+// so report never blocks the worker. It is closed once the promise settles,
+// even if fn is still running after a timeout, and later reports are ignored.
+func (e *EventLoop) AsyncProgress(fn func(report func(p float64)) (interface{}, error)) (*Promise, <-chan float64) {
+	p := e.newPromise("")
+	report, progress := latest[float64](p)
+	return e.runAsync(p, func() (interface{}, error) {
+		return fn(report)
+	}), progress
+}
+
+// AsyncRefining is Async for work that has a usable result before its final
+// one, e.g. a cached value followed by a fresh one. fn calls emit with each
+// preliminary result, and its return value settles the promise as usual. Like
+// AsyncProgress, the channel holds only the latest preliminary result, so emit
+// never blocks. It is closed once the promise settles, and later emits are
+// ignored.
+func (e *EventLoop) AsyncRefining(fn func(emit func(interface{})) (interface{}, error)) (*Promise, <-chan interface{}) {
+	p := e.newPromise("")
+	emit, results := latest[interface{}](p)
+	return e.runAsync(p, func() (interface{}, error) {
+		return fn(emit)
+	}), results
+}
+
+// AsyncCloser is Async for work that yields a resource. Once the promise
+// resolves with the ReadCloser, the consumer owns it and must close it. If the
+// promise settles first, e.g. through WithTimeout, ctx is cancelled and a
+// ReadCloser that fn still returns is closed by the loop, as is one returned
+// together with an error.
+func (e *EventLoop) AsyncCloser(fn func(ctx context.Context) (io.ReadCloser, error)) *Promise {
+	ctx, cancel := context.WithCancel(context.Background())
+	var returned uint32
+	p := e.newPromise("")
+	p.discard = func(value interface{}) {
+		if rc, ok := value.(io.ReadCloser); ok {
+			rc.Close()
+		}
+	}
+	go func() {
+		<-p.settled
+		// fn is still running, so the promise settled without it
+		if atomic.LoadUint32(&returned) == 0 {
+			cancel()
+		}
+	}()
+	return e.runAsync(p, func() (interface{}, error) {
+		defer atomic.StoreUint32(&returned, 1)
+		rc, err := fn(ctx)
+		if err != nil {
+			if rc != nil {
+				rc.Close()
+			}
+			return nil, err
+		}
+		return rc, nil
+	})
+}
+
+// AsyncCancelable is Async for work that can be torn down from outside. The
+// returned CancelFunc rejects the promise with context.Canceled. Whenever the
+// promise settles before fn returns, through the CancelFunc, WithTimeout or
+// otherwise, ctx is cancelled and abort is called to force the work to stop,
+// e.g. by closing its connection. abort runs at most once, only once fn has
+// started and never after it has returned, and fn's late result is
+// discarded. Combinators such as Some cancel the promise themselves once they
+// no longer need it.
+func (e *EventLoop) AsyncCancelable(fn func(ctx context.Context) (interface{}, error), abort func()) (*Promise, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	started, returned := false, false
+	p := e.newPromise("")
+	go func() {
+		<-p.settled
+		cancel()
+		// held while abort runs, so fn cannot be seen starting or returning
+		// before it
+		mu.Lock()
+		defer mu.Unlock()
+		if !started || returned {
+			return
+		}
+		if _, err := call(func() (interface{}, error) {
+			abort()
+			return nil, nil
+		}); err != nil {
+			e.errorf("eventloop: %s: recovered panic in abort: %v", p.ref(), err)
+		}
+	}()
+	p.cancel = func() {
+		go e.promiseRecovery(p, e.deliveryMode())(nil, context.Canceled)
+	}
+	e.runAsync(p, func() (interface{}, error) {
+		mu.Lock()
+		started = true
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			returned = true
+			mu.Unlock()
+		}()
+		return fn(ctx)
+	})
+	return p, p.cancel
+}
+
+// AsyncReader resolves straight away with an io.ReadCloser that streams what
+// fn writes to w, so large output is read as it is produced rather than
+// buffered. Once fn returns, reads drain what is left and then return fn's
+// error, or io.EOF if it returned nil. Closing the reader makes fn's further
+// writes fail with io.ErrClosedPipe, and the loop closes it itself if the
+// promise settles without it, e.g. through WithTimeout. fn runs on its own
+// goroutine, outside SetMaxConcurrency.
+func (e *EventLoop) AsyncReader(fn func(w io.Writer) error) *Promise {
+	pr, pw := io.Pipe()
+	p := e.newPromise("")
+	p.discard = func(interface{}) {
+		pr.Close()
+	}
+	return e.runAsync(p, func() (interface{}, error) {
+		go func() {
+			_, err := call(func() (interface{}, error) {
+				return nil, fn(pw)
+			})
+			pw.CloseWithError(err)
+		}()
+		return io.ReadCloser(pr), nil
+	})
+}
+
+// AsyncStream runs fn as an Async worker that streams its result in chunks:
+// each emit signals a completion event on the returned future, and the
+// promise settles once fn returns, resolving with nil or rejecting with its
+// error. emit takes part in backpressure: it returns once the future's
+// derived listeners (Map, ThenCoalesced and the like) have taken the chunk,
+// and it returns an error instead of delivering when nothing is registered on
+// the future (ErrNoHandler) or fn has already returned (ErrStreamClosed).
+func (e *EventLoop) AsyncStream(fn func(emit func(chunk interface{}) error) error) (*Future, *Promise) {
+	f := e.NewFuture()
+	var mu sync.Mutex
+	closed := false
+	emit := func(chunk interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return ErrStreamClosed
+		}
+		return f.ResolveFuture(chunk)
+	}
+	p := e.Async(func() (interface{}, error) {
+		defer func() {
+			mu.Lock()
+			closed = true
+			mu.Unlock()
+		}()
+		return nil, fn(emit)
+	})
+	return f, p
+}
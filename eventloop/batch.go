@@ -0,0 +1,165 @@
+package eventloop
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrMissingKey = errors.New("eventloop: fetch returned no result for key")
+
+type batchSlot struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+type batchQueue struct {
+	loop    *EventLoop
+	maxSize int
+	maxWait time.Duration
+	flush   func([]interface{}) ([]interface{}, error)
+
+	mu    sync.Mutex
+	items []interface{}
+	slots []*batchSlot
+	timer *time.Timer
+	// gen counts flushes, so a timer that fired for an earlier batch
+	// leaves the current one alone
+	gen int
+}
+
+// Batcher coalesces submitted items into calls of flush, like a dataloader.
+// A batch is flushed once it holds maxSize items (maxSize <= 0 means no size
+// limit) or maxWait after its first item, whichever is sooner. flush must
+// return one result per item, in the same order; each item's promise resolves
+// with its result. An error from flush, or a result count that does not match,
+// rejects every promise in the batch.
+func (e *EventLoop) Batcher(maxSize int, maxWait time.Duration, flush func([]interface{}) ([]interface{}, error)) func(item interface{}) *Promise {
+	q := &batchQueue{loop: e, maxSize: maxSize, maxWait: maxWait, flush: flush}
+	return q.submit
+}
+
+func (q *batchQueue) submit(item interface{}) *Promise {
+	slot := &batchSlot{done: make(chan struct{})}
+	q.mu.Lock()
+	q.items = append(q.items, item)
+	q.slots = append(q.slots, slot)
+	if q.maxSize > 0 && len(q.items) >= q.maxSize {
+		q.flushLocked()
+	} else if q.timer == nil {
+		gen := q.gen
+		q.timer = time.AfterFunc(q.maxWait, func() {
+			q.flushTimer(gen)
+		})
+	}
+	q.mu.Unlock()
+
+	return q.loop.coordinate(func() (interface{}, error) {
+		<-slot.done
+		return slot.value, slot.err
+	})
+}
+
+func (q *batchQueue) flushTimer(gen int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.gen == gen {
+		q.flushLocked()
+	}
+}
+
+// flushLocked starts flushing the pending batch; q.mu is held.
+func (q *batchQueue) flushLocked() {
+	if q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+	q.gen++
+	items, slots := q.items, q.slots
+	q.items, q.slots = nil, nil
+	if len(items) == 0 {
+		return
+	}
+	go func() {
+		value, err := q.loop.Await(q.loop.Async(func() (interface{}, error) {
+			return q.flush(items)
+		}))
+		results, _ := value.([]interface{})
+		if err == nil && len(results) != len(items) {
+			err = fmt.Errorf("eventloop: batch of %d items got %d results", len(items), len(results))
+		}
+		for i, slot := range slots {
+			if err != nil {
+				slot.err = err
+			} else {
+				slot.value = results[i]
+			}
+			close(slot.done)
+		}
+	}()
+}
+
+type coalescer struct {
+	loop   *EventLoop
+	window time.Duration
+	fetch  func([]string) (map[string]interface{}, error)
+
+	mu      sync.Mutex
+	pending map[string]*batchSlot
+	keys    []string
+}
+
+// Coalesce is Batcher for keyed lookups: the keys requested within window of
+// the first one are fetched with a single call of fetch, and a key requested
+// more than once in a window is fetched once, with every request for it
+// sharing the result. Each key's promise resolves with its entry in the map
+// fetch returns, or rejects with ErrMissingKey if there is none; an error
+// from fetch rejects every key of the window.
+func (e *EventLoop) Coalesce(window time.Duration, fetch func([]string) (map[string]interface{}, error)) func(key string) *Promise {
+	c := &coalescer{loop: e, window: window, fetch: fetch}
+	return c.load
+}
+
+func (c *coalescer) load(key string) *Promise {
+	c.mu.Lock()
+	slot, ok := c.pending[key]
+	if !ok {
+		if c.pending == nil {
+			c.pending = make(map[string]*batchSlot)
+			time.AfterFunc(c.window, c.flush)
+		}
+		slot = &batchSlot{done: make(chan struct{})}
+		c.pending[key] = slot
+		c.keys = append(c.keys, key)
+	}
+	c.mu.Unlock()
+
+	return c.loop.coordinate(func() (interface{}, error) {
+		<-slot.done
+		return slot.value, slot.err
+	})
+}
+
+func (c *coalescer) flush() {
+	c.mu.Lock()
+	keys, slots := c.keys, c.pending
+	c.keys, c.pending = nil, nil
+	c.mu.Unlock()
+
+	value, err := c.loop.Await(c.loop.Async(func() (interface{}, error) {
+		return c.fetch(keys)
+	}))
+	results, _ := value.(map[string]interface{})
+	for key, slot := range slots {
+		if err != nil {
+			slot.err = err
+		} else if v, ok := results[key]; ok {
+			slot.value = v
+		} else {
+			slot.err = fmt.Errorf("%w %q", ErrMissingKey, key)
+		}
+		close(slot.done)
+	}
+}
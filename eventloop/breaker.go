@@ -0,0 +1,136 @@
+package eventloop
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrBreakerOpen = errors.New("eventloop: circuit breaker is open")
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerSettings configures a CircuitBreaker. It opens after
+// FailureThreshold failures in a row (at least 1) and stays open for
+// Cooldown, after which one probe call decides whether it closes again.
+type BreakerSettings struct {
+	FailureThreshold int
+	Cooldown         time.Duration
+}
+
+// CircuitBreaker guards calls to a dependency; it is safe for concurrent use.
+type CircuitBreaker struct {
+	loop     *EventLoop
+	settings BreakerSettings
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+}
+
+func (e *EventLoop) Breaker(settings BreakerSettings) *CircuitBreaker {
+	if settings.FailureThreshold < 1 {
+		settings.FailureThreshold = 1
+	}
+	return &CircuitBreaker{loop: e, settings: settings}
+}
+
+// State reports the breaker's state; an open breaker whose cooldown has passed
+// reports half-open, since its next call is a probe.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.settings.Cooldown {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// Do runs fn as an Async worker if the breaker lets it through. While the
+// breaker is open, and while a half-open probe is in flight, it returns a
+// promise rejected with ErrBreakerOpen without running fn. The outcome is
+// recorded once the promise settles, so a panic or timeout counts as a
+// failure; a call that is shed before fn starts is not recorded, and a probe
+// shed that way leaves the next call to probe instead.
+func (b *CircuitBreaker) Do(fn func() (interface{}, error)) *Promise {
+	if !b.allow() {
+		return b.loop.RejectedChain(ErrBreakerOpen)
+	}
+	var started int32
+	p := b.loop.Async(func() (interface{}, error) {
+		atomic.StoreInt32(&started, 1)
+		return call(fn)
+	})
+	go func() {
+		<-p.settled
+		if atomic.LoadInt32(&started) == 0 {
+			b.release()
+			return
+		}
+		b.record(p.reason)
+	}()
+	return p
+}
+
+func (b *CircuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.settings.Cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		return true
+	case BreakerHalfOpen:
+		return false
+	default:
+		return true
+	}
+}
+
+// release undoes allow for a probe that never ran, so the breaker is open
+// with its cooldown already passed.
+func (b *CircuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.state = BreakerOpen
+	}
+}
+
+func (b *CircuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.settings.FailureThreshold {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
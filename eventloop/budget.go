@@ -0,0 +1,44 @@
+package eventloop
+
+import (
+	"context"
+	"time"
+)
+
+type budgetKey struct{}
+
+// AsyncBudget is AsyncContext for a chain that must finish within total,
+// end to end: fn and every stage derived from the promise (ThenMap, ThenCtx
+// and the like) share one deadline instead of each getting its own. A stage
+// still running when the budget runs out, or reached after it has, rejects
+// with ErrTimeout, and the context that fn and ThenCtx stages receive is done
+// at the deadline. BudgetRemaining reports what is left.
+func (e *EventLoop) AsyncBudget(total time.Duration, fn func(ctx context.Context) (interface{}, error)) *Promise {
+	deadline := time.Now().Add(total)
+	ctx, cancel := context.WithDeadline(context.WithValue(context.Background(), budgetKey{}, deadline), deadline)
+	// later stages keep using ctx, so it is only released at the deadline
+	time.AfterFunc(total, cancel)
+	return e.AsyncContext(ctx, fn).WithTimeout(total)
+}
+
+// BudgetRemaining returns how much of an AsyncBudget is left, given the ctx
+// passed to the chain's worker or a ThenCtx stage. ok is false for a ctx that
+// does not belong to a budgeted chain.
+func BudgetRemaining(ctx context.Context) (remaining time.Duration, ok bool) {
+	deadline, ok := budgetDeadline(ctx)
+	if !ok {
+		return 0, false
+	}
+	if remaining = time.Until(deadline); remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true
+}
+
+func budgetDeadline(ctx context.Context) (time.Time, bool) {
+	if ctx == nil {
+		return time.Time{}, false
+	}
+	deadline, ok := ctx.Value(budgetKey{}).(time.Time)
+	return deadline, ok
+}
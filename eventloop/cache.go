@@ -0,0 +1,189 @@
+package eventloop
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var ErrUnhashable = errors.New("eventloop: argument cannot be used as a cache key")
+
+type cacheEntry struct {
+	done    chan struct{}
+	value   interface{}
+	err     error
+	expires time.Time // zero while fn is still running
+}
+
+type asyncCache struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+// CachedAsync is Async memoized by key: a successful result is reused for ttl,
+// and concurrent calls for a key that is not cached share a single run of fn.
+// Errors are never cached, so the next call after a failure runs fn again.
+// Expired entries are evicted whenever CachedAsync is called.
+func (e *EventLoop) CachedAsync(key string, ttl time.Duration, fn func() (interface{}, error)) *Promise {
+	entry := e.cache.lookup(key, ttl, func() (interface{}, error) {
+		return e.Await(e.Async(fn))
+	})
+	return e.coordinate(func() (interface{}, error) {
+		<-entry.done
+		return entry.value, entry.err
+	})
+}
+
+func (c *asyncCache) lookup(key string, ttl time.Duration, fn func() (interface{}, error)) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for k, entry := range c.entries {
+		if !entry.expires.IsZero() && !now.Before(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	if entry, ok := c.entries[key]; ok {
+		return entry
+	}
+
+	if c.entries == nil {
+		c.entries = make(map[string]*cacheEntry)
+	}
+	entry := &cacheEntry{done: make(chan struct{})}
+	c.entries[key] = entry
+	go func() {
+		value, err := call(fn)
+		c.mu.Lock()
+		entry.value, entry.err = value, err
+		if err != nil {
+			if c.entries[key] == entry {
+				delete(c.entries, key)
+			}
+		} else {
+			entry.expires = time.Now().Add(ttl)
+		}
+		c.mu.Unlock()
+		close(entry.done)
+	}()
+	return entry
+}
+
+// Pure memoizes fn, which must be pure, by its arguments: a call with
+// arguments equal (==) to an earlier call's returns that call's promise
+// without running fn again, so concurrent equal calls share one run. As with
+// CachedAsync, a rejection is not kept, whether from fn, a panic or a
+// timeout, and the next equal call runs fn again. Arguments must be
+// comparable; a call with a slice, map or func argument, or a value holding
+// one, returns a promise rejected with ErrUnhashable.
+func (e *EventLoop) Pure(fn func(args ...interface{}) (interface{}, error)) func(args ...interface{}) *Promise {
+	var mu sync.Mutex
+	calls := make(map[interface{}]*Promise)
+	return func(args ...interface{}) *Promise {
+		key, err := argsKey(args)
+		if err != nil {
+			return e.RejectedChain(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if p, ok := calls[key]; ok && p.State() != Rejected {
+			return p
+		}
+		p := e.Async(func() (interface{}, error) {
+			return fn(args...)
+		})
+		calls[key] = p
+		go func() {
+			<-p.settled
+			if p.State() != Rejected {
+				return
+			}
+			mu.Lock()
+			if calls[key] == p {
+				delete(calls, key)
+			}
+			mu.Unlock()
+		}()
+		return p
+	}
+}
+
+// argsKey packs args into an array of interfaces, which is == to another
+// exactly when their arguments are.
+func argsKey(args []interface{}) (key interface{}, err error) {
+	for i, arg := range args {
+		if arg != nil && !reflect.TypeOf(arg).Comparable() {
+			return nil, fmt.Errorf("%w: argument %d has type %T", ErrUnhashable, i, arg)
+		}
+	}
+	arr := reflect.New(reflect.ArrayOf(len(args), reflect.TypeOf((*interface{})(nil)).Elem())).Elem()
+	reflect.Copy(arr, reflect.ValueOf(args))
+	// a comparable type can still hold an uncomparable value in an
+	// interface field, which only shows when the key is hashed
+	defer func() {
+		if r := recover(); r != nil {
+			key, err = nil, fmt.Errorf("%w: %v", ErrUnhashable, r)
+		}
+	}()
+	key = arr.Interface()
+	_ = map[interface{}]bool{key: true}
+	return key, nil
+}
+
+// CacheToFile persists p's value across runs of the program. If path holds a
+// value that decode accepts, the derived promise resolves with it without
+// waiting for p, whose outcome then counts as handled; combined with Lazy,
+// p's work is never run. Otherwise it waits for p and, once p resolves,
+// writes encode(value) to path before resolving with the value; a rejection
+// of p is passed on and nothing is written. A file that cannot be read or
+// decoded counts as a miss, and a failed write is logged without affecting
+// the result. The file is never invalidated: remove it when the cached value
+// is stale.
+func (p *Promise) CacheToFile(path string, encode func(interface{}) ([]byte, error), decode func([]byte) (interface{}, error)) *Promise {
+	return p.stageOr(false, func() (interface{}, bool) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, false
+		}
+		value, err := decode(data)
+		if err != nil {
+			p.loop.debugf("eventloop: cache file %s: %v", path, err)
+			return nil, false
+		}
+		return value, true
+	}, func(value interface{}, err error) (interface{}, error) {
+		if err != nil {
+			return nil, err
+		}
+		if err := writeCacheFile(path, value, encode); err != nil {
+			p.loop.errorf("eventloop: cache file %s: %v", path, err)
+		}
+		return value, nil
+	})
+}
+
+// writeCacheFile writes through a temporary file, so a reader never sees a
+// partly written cache.
+func writeCacheFile(path string, value interface{}, encode func(interface{}) ([]byte, error)) error {
+	data, err := encode(value)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
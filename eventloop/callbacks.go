@@ -0,0 +1,80 @@
+package eventloop
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// callbackQueue runs callbacks one at a time, in the order they were queued
+// or in a random one under SetExecutionSeed, on a single goroutine started on
+// first use.
+type callbackQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	started bool
+	tasks   []func()
+	rng     *rand.Rand
+}
+
+// SetSerialCallbacks runs Then, Catch and Finally callbacks one at a time on a
+// single executor goroutine of the loop, in the order their promises let them
+// run, like a JavaScript microtask queue. A callback then never runs at the
+// same time as another, but it must not wait for a later callback to run. By
+// default every callback runs on its own goroutine.
+func (e *EventLoop) SetSerialCallbacks(serial bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.serial = serial
+}
+
+func (e *EventLoop) serialCallbacks() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.serial
+}
+
+// dispatch runs fn and returns once it has finished, on the calling goroutine
+// or, with SetSerialCallbacks, on the executor.
+func (e *EventLoop) dispatch(fn func()) {
+	if !e.serialCallbacks() {
+		fn()
+		return
+	}
+	done := make(chan struct{})
+	e.callbacks.push(func() {
+		defer close(done)
+		fn()
+	})
+	<-done
+}
+
+func (q *callbackQueue) push(task func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.started {
+		q.started = true
+		q.cond = sync.NewCond(&q.mu)
+		go q.run()
+	}
+	q.tasks = append(q.tasks, task)
+	q.cond.Signal()
+}
+
+func (q *callbackQueue) run() {
+	for {
+		q.mu.Lock()
+		for len(q.tasks) == 0 {
+			q.cond.Wait()
+		}
+		next := 0
+		if q.rng != nil {
+			next = q.rng.Intn(len(q.tasks))
+		}
+		task := q.tasks[next]
+		copy(q.tasks[next:], q.tasks[next+1:])
+		q.tasks[len(q.tasks)-1] = nil
+		q.tasks = q.tasks[:len(q.tasks)-1]
+		q.mu.Unlock()
+		task()
+	}
+}
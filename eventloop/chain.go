@@ -0,0 +1,598 @@
+package eventloop
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrChannelClosed = errors.New("eventloop: channel closed without a value")
+	ErrTimeout       = errors.New("eventloop: promise timed out")
+)
+
+type stopValue struct {
+	value interface{}
+}
+
+// Stop ends a chain early. A stage that returns (Stop(v), nil) resolves its
+// promise with v, and every derived stage after it (ThenMap, ThenChannel,
+// Branch, CatchIs, CatchAs, Bridge) is skipped and resolves with v as well;
+// Finally and FinallyMap still run.
+// Await, Then and Catch are not stages: they see v like any other value.
+func Stop(value interface{}) interface{} {
+	return stopValue{value: value}
+}
+
+// derive returns a promise settled by fn applied to p's outcome, or by p's
+// value unchanged if p was stopped. It inherits p's context, and with it the
+// deadline of an AsyncBudget.
+func (p *Promise) derive(fn func(value interface{}, err error) (interface{}, error)) *Promise {
+	return p.stage(false, fn)
+}
+
+// stage is derive; with always set, fn runs on a stopped chain too, and on one
+// past its budget, and a value it returns on a stopped chain keeps it stopped.
+func (p *Promise) stage(always bool, fn func(value interface{}, err error) (interface{}, error)) *Promise {
+	return p.stageOr(always, nil, fn)
+}
+
+// stageOr is stage with a shortcut: when hit reports a value, the stage
+// resolves with it without waiting for p, whose outcome then counts as
+// handled. The stage's span covers hit, and fn too after a miss.
+func (p *Promise) stageOr(always bool, hit func() (interface{}, bool), fn func(value interface{}, err error) (interface{}, error)) *Promise {
+	q := p.loop.add(&Promise{ctx: p.ctx, parent: p})
+	// a span per stage, named like a Then's after the nearest named promise
+	// up the chain, numbered by how far below it the stage is
+	anchor, depth := p, 1
+	for anchor.name == "" && anchor.parent != nil {
+		anchor, depth = anchor.parent, depth+1
+	}
+	span := func(run func() (interface{}, error)) (result interface{}, rerr error) {
+		end := p.loop.startSpan(anchor, depth)
+		defer func() {
+			if r := recover(); r != nil {
+				end(recoveredError(r))
+				panic(r)
+			}
+			end(rerr)
+		}()
+		return run()
+	}
+	traced := func(value interface{}, err error) (interface{}, error) {
+		if hit != nil {
+			return fn(value, err)
+		}
+		return span(func() (interface{}, error) {
+			return fn(value, err)
+		})
+	}
+	deadline, budgeted := budgetDeadline(p.ctx)
+	settle := func() (interface{}, error) {
+		value, err := p.loop.Await(p)
+		stopped := err == nil && p.stopped
+		if always {
+			value, err = traced(value, err)
+			if stopped && err == nil {
+				return Stop(value), nil
+			}
+			return value, err
+		}
+		if stopped {
+			return Stop(value), nil
+		}
+		if err == nil && budgeted && !time.Now().Before(deadline) {
+			return nil, ErrTimeout
+		}
+		return traced(value, err)
+	}
+	p.loop.run(q, false, func() (interface{}, error) {
+		if hit == nil {
+			return settle()
+		}
+		return span(func() (interface{}, error) {
+			if value, ok := hit(); ok {
+				p.valueHandled.fire()
+				p.errHandled.fire()
+				return value, nil
+			}
+			return settle()
+		})
+	})
+	if budgeted {
+		q.WithTimeout(time.Until(deadline))
+	}
+	return q
+}
+
+// ThenMap resolves the derived promise with fn(value). A rejection of p skips
+// fn, and a panic in fn rejects the derived promise. A nil fn passes the value
+// through, like Then(nil); so does a nil callback for the other stages.
+func (p *Promise) ThenMap(fn func(interface{}) (interface{}, error)) *Promise {
+	return p.derive(func(value interface{}, err error) (interface{}, error) {
+		if err != nil || fn == nil {
+			return value, err
+		}
+		return fn(value)
+	})
+}
+
+// Validate passes p's value through once schema accepts it, and rejects with
+// schema's error otherwise, checking this one stage where SetResultValidator
+// checks every promise. A panic in schema rejects too, and a rejection of p is
+// passed on without calling schema.
+func (p *Promise) Validate(schema func(interface{}) error) *Promise {
+	return p.ThenMap(func(value interface{}) (interface{}, error) {
+		if schema == nil {
+			return value, nil
+		}
+		if err := schema(value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	})
+}
+
+// ThenValidated is Validate followed by ThenMap in a single stage: p's value
+// reaches transform only once validate accepts it. An error or panic from
+// either rejects the derived promise, and a rejection of p skips both.
+func (p *Promise) ThenValidated(validate func(interface{}) error, transform func(interface{}) (interface{}, error)) *Promise {
+	return p.ThenMap(func(value interface{}) (interface{}, error) {
+		if validate != nil {
+			if err := validate(value); err != nil {
+				return nil, err
+			}
+		}
+		if transform == nil {
+			return value, nil
+		}
+		return transform(value)
+	})
+}
+
+// Finally runs fn once p settles, either way, and passes p's outcome on
+// unchanged. It runs on a stopped chain too, and with SetSerialCallbacks on
+// the same executor as Then and Catch. A panic in fn is logged and does not
+// change the outcome.
+func (p *Promise) Finally(fn func()) *Promise {
+	return p.stage(true, func(value interface{}, err error) (interface{}, error) {
+		if fn == nil {
+			return value, err
+		}
+		p.loop.dispatch(func() {
+			if _, perr := call(func() (interface{}, error) {
+				fn()
+				return nil, nil
+			}); perr != nil {
+				p.loop.errorf("eventloop: %s: recovered panic in Finally: %v", p.ref(), perr)
+			}
+		})
+		return value, err
+	})
+}
+
+// FinallyMap is Finally for cleanup that decides the outcome: whatever fn
+// returns settles the derived promise, in place of p's outcome. That cuts both
+// ways. fn sees rejections as well as values, and returning a nil error turns
+// a rejection into a success, so to keep p's outcome fn must return value and
+// err as given; (nil, nil) resolves with nil. A panic in fn rejects. On a
+// stopped chain the value fn returns stays stopped.
+func (p *Promise) FinallyMap(fn func(value interface{}, err error) (interface{}, error)) *Promise {
+	if fn == nil {
+		fn = func(value interface{}, err error) (interface{}, error) {
+			return value, err
+		}
+	}
+	return p.stage(true, fn)
+}
+
+// DiffError reports a DiffAgainst mismatch.
+type DiffError struct {
+	Diff string
+}
+
+func (e *DiffError) Error() string {
+	return "eventloop: result differs from golden:\n" + e.Diff
+}
+
+// DiffAgainst resolves with p's value if diff reports it equal to golden, and
+// otherwise rejects with a *DiffError holding diff's description of the
+// difference. diff gets the value first and golden second and can be any
+// comparison, go-cmp's for instance. A rejection of p is passed on, and a
+// panic in diff rejects.
+func (e *EventLoop) DiffAgainst(p *Promise, golden interface{}, diff func(got, want interface{}) (string, bool)) *Promise {
+	return p.ThenMap(func(value interface{}) (interface{}, error) {
+		if text, equal := diff(value, golden); !equal {
+			return nil, &DiffError{Diff: text}
+		}
+		return value, nil
+	})
+}
+
+// ThenChannel resolves the derived promise with the first value received from
+// the channel fn returns, or rejects with ErrChannelClosed if it is closed
+// first. A rejection of p skips fn.
+func (p *Promise) ThenChannel(fn func(interface{}) <-chan interface{}) *Promise {
+	return p.derive(func(value interface{}, err error) (interface{}, error) {
+		if err != nil || fn == nil {
+			return value, err
+		}
+		v, ok := <-fn(value)
+		if !ok {
+			return nil, ErrChannelClosed
+		}
+		return v, nil
+	})
+}
+
+// Bridge awaits src on its own loop and then runs fn with its value on dst,
+// returning dst's promise. Each loop only tracks its own promise, so Main on
+// either loop drains correctly. A rejection of src skips fn.
+func Bridge(src *Promise, dst *EventLoop, fn func(interface{}) (interface{}, error)) *Promise {
+	return dst.coordinate(func() (interface{}, error) {
+		value, err := src.loop.Await(src)
+		if err != nil {
+			return nil, err
+		}
+		if src.stopped {
+			return Stop(value), nil
+		}
+		if fn == nil {
+			return value, nil
+		}
+		return dst.Await(dst.Async(func() (interface{}, error) {
+			return fn(value)
+		}))
+	})
+}
+
+// WithTimeoutLate rejects the derived promise with ErrTimeout if p has not
+// settled within d. If p settles after that, onLate is called once with its
+// outcome so the late work is not wasted.
+func (p *Promise) WithTimeoutLate(d time.Duration, onLate func(interface{}, error)) *Promise {
+	return p.loop.coordinate(func() (interface{}, error) {
+		settled := watch(p)
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case r := <-settled:
+			return r.Value, r.Err
+		case <-timer.C:
+		}
+
+		if onLate != nil {
+			go func() {
+				r := <-settled
+				if _, err := call(func() (interface{}, error) {
+					onLate(r.Value, r.Err)
+					return nil, nil
+				}); err != nil {
+					p.loop.errorf("eventloop: %s: recovered panic in late callback: %v", p.ref(), err)
+				}
+			}()
+		}
+		return nil, ErrTimeout
+	})
+}
+
+// CatchIs handles only rejections matching sentinel under errors.Is. The
+// derived promise resolves with nil after fn runs, rejects with any other
+// error unchanged so a later Catch can handle it, and passes values through.
+func (p *Promise) CatchIs(sentinel error, fn func(error)) *Promise {
+	return p.derive(func(value interface{}, err error) (interface{}, error) {
+		if err == nil {
+			return value, nil
+		}
+		if fn == nil || !errors.Is(err, sentinel) {
+			return nil, err
+		}
+		fn(err)
+		return nil, nil
+	})
+}
+
+// CatchAs is CatchIs for error types: when errors.As can assign the rejection
+// to target, fn runs and may read target.
+func (p *Promise) CatchAs(target interface{}, fn func()) *Promise {
+	return p.derive(func(value interface{}, err error) (interface{}, error) {
+		if err == nil {
+			return value, nil
+		}
+		if fn == nil || !errors.As(err, target) {
+			return nil, err
+		}
+		fn()
+		return nil, nil
+	})
+}
+
+// Branch resolves the derived promise with ifTrue(value) when pred(value)
+// holds and with ifFalse(value) otherwise. A rejection of p skips both, and a
+// panic in pred or a branch rejects the derived promise.
+func (p *Promise) Branch(pred func(interface{}) bool, ifTrue, ifFalse func(interface{}) (interface{}, error)) *Promise {
+	return p.derive(func(value interface{}, err error) (interface{}, error) {
+		if err != nil {
+			return nil, err
+		}
+		branch := ifFalse
+		if pred != nil && pred(value) {
+			branch = ifTrue
+		}
+		if branch == nil {
+			return value, nil
+		}
+		return branch(value)
+	})
+}
+
+// Expand runs every task fn returns for p's value and resolves the derived
+// promise with their results in order. The first task error stops tasks that
+// have not started and rejects the derived promise. A rejection of p skips fn.
+func (p *Promise) Expand(fn func(interface{}) []func() (interface{}, error)) *Promise {
+	return p.ExpandN(fn, 0)
+}
+
+// ExpandN is Expand with at most concurrency tasks in flight (unbounded when
+// concurrency <= 0).
+func (p *Promise) ExpandN(fn func(interface{}) []func() (interface{}, error), concurrency int) *Promise {
+	return p.derive(func(value interface{}, err error) (interface{}, error) {
+		if err != nil || fn == nil {
+			return value, err
+		}
+		tasks := fn(value)
+		if concurrency <= 0 || concurrency > len(tasks) {
+			concurrency = len(tasks)
+		}
+		return p.loop.mapOrdered(len(tasks), make(countLimiter, concurrency), func(i int) (interface{}, error) {
+			return tasks[i]()
+		})
+	})
+}
+
+// OrElse resolves the derived promise with p's value, or settles it with
+// fallback's outcome if p rejects. fallback has usually started already and
+// runs either way; use OrElseFunc to start it only when p fails.
+func (p *Promise) OrElse(fallback *Promise) *Promise {
+	return p.OrElseFunc(func() *Promise {
+		return fallback
+	})
+}
+
+// OrElseFunc is OrElse with a fallback made by fn, which is called only if p
+// rejects.
+func (p *Promise) OrElseFunc(fn func() *Promise) *Promise {
+	return p.derive(func(value interface{}, err error) (interface{}, error) {
+		if err == nil || fn == nil {
+			return value, err
+		}
+		fallback := fn()
+		return fallback.loop.Await(fallback)
+	})
+}
+
+// ThenFirst runs fn exactly once, with the first value p's result yields, and
+// resolves the derived promise with that value. For an ordinary value that is
+// the value itself, as with Then; when p resolves with a multi-emit source,
+// only its first value is taken:
+//
+//   - a <-chan interface{} or chan interface{}: the first value received;
+//     nothing more is read, and a channel closed before sending rejects with
+//     ErrChannelClosed.
+//   - a *Future: the first later completion event (error events are skipped),
+//     after which the listener is removed from the future; a future closed
+//     before one arrives rejects with ErrStreamClosed.
+//
+// A rejection of p skips fn, and a panic in fn rejects the derived promise.
+func (p *Promise) ThenFirst(fn func(interface{})) *Promise {
+	return p.derive(func(value interface{}, err error) (interface{}, error) {
+		if err != nil {
+			return nil, err
+		}
+		switch src := receiveOnly(value).(type) {
+		case <-chan interface{}:
+			v, ok := <-src
+			if !ok {
+				return nil, ErrChannelClosed
+			}
+			value = v
+		case *Future:
+			first := make(chan interface{}, 1)
+			var once sync.Once
+			stop := src.listen(func(v interface{}, err error) {
+				if err == nil {
+					once.Do(func() {
+						first <- v
+					})
+				}
+			})
+			select {
+			case value = <-first:
+				stop()
+			case <-src.closed:
+				stop()
+				return nil, ErrStreamClosed
+			}
+		}
+		if fn != nil {
+			fn(value)
+		}
+		return value, nil
+	})
+}
+
+// receiveOnly returns a chan interface{} as a <-chan interface{}, so stages
+// that read from channels take both; any other value is returned unchanged.
+func receiveOnly(value interface{}) interface{} {
+	if ch, ok := value.(chan interface{}); ok {
+		return (<-chan interface{})(ch)
+	}
+	return value
+}
+
+// Tee calls every sink with p's outcome, value or error, and passes the
+// outcome on unchanged once they have all returned, e.g. to feed metrics, a
+// cache and a log from one stage. The sinks run concurrently; a panic in one
+// is logged and affects neither the others nor the chain.
+func (p *Promise) Tee(sinks ...func(value interface{}, err error)) *Promise {
+	return p.derive(func(value interface{}, err error) (interface{}, error) {
+		var wg sync.WaitGroup
+		for _, sink := range sinks {
+			if sink == nil {
+				continue
+			}
+			wg.Add(1)
+			go func(sink func(interface{}, error)) {
+				defer wg.Done()
+				if _, perr := call(func() (interface{}, error) {
+					sink(value, err)
+					return nil, nil
+				}); perr != nil {
+					p.loop.errorf("eventloop: %s: recovered panic in Tee sink: %v", p.ref(), perr)
+				}
+			}(sink)
+		}
+		wg.Wait()
+		return value, err
+	})
+}
+
+// StateMachine consumes the events Drive feeds it, one at a time.
+type StateMachine interface {
+	Next(event interface{}) (newState interface{}, done bool, err error)
+}
+
+// Drive feeds the events p's result yields into sm until Next reports done,
+// and resolves the derived promise with the final state. Events come from the
+// same sources as ThenFirst: each value received from a <-chan interface{} or
+// chan interface{}, where a channel closed before done rejects with
+// ErrChannelClosed; each later completion event of a *Future, where an error
+// event, or the future closing before done with ErrStreamClosed, rejects; or
+// an ordinary value as a single event, after which the state is the result
+// whether or not sm is done. An error or panic from Next rejects, and a
+// rejection of p skips sm.
+func (p *Promise) Drive(sm StateMachine) *Promise {
+	return p.derive(func(value interface{}, err error) (interface{}, error) {
+		if err != nil || sm == nil {
+			return value, err
+		}
+		var state interface{}
+		next := func(event interface{}) (done bool, err error) {
+			_, err = call(func() (interface{}, error) {
+				var err error
+				state, done, err = sm.Next(event)
+				return nil, err
+			})
+			return done || err != nil, err
+		}
+		switch src := receiveOnly(value).(type) {
+		case <-chan interface{}:
+			for event := range src {
+				if done, err := next(event); err != nil {
+					return nil, err
+				} else if done {
+					return state, nil
+				}
+			}
+			return nil, ErrChannelClosed
+		case *Future:
+			var mu sync.Mutex
+			finished := false
+			result := make(chan error, 1)
+			stop := src.listen(func(event interface{}, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				if finished {
+					return
+				}
+				if err == nil {
+					finished, err = next(event)
+				} else {
+					finished = true
+				}
+				if finished {
+					result <- err
+				}
+			})
+			var err error
+			select {
+			case err = <-result:
+				stop()
+			case <-src.closed:
+				stop()
+				mu.Lock()
+				if finished {
+					err = <-result
+				} else {
+					finished, err = true, ErrStreamClosed
+				}
+				mu.Unlock()
+			}
+			if err != nil {
+				return nil, err
+			}
+			return state, nil
+		}
+		if _, err := next(value); err != nil {
+			return nil, err
+		}
+		return state, nil
+	})
+}
+
+// ThenThrottle is ThenMap for stages that ask for a pause, e.g. to honour a
+// Retry-After header: the derived promise resolves with fn's value only after
+// the returned delay, or straight away for a delay <= 0. If the chain's
+// context (see AsyncContext) is done during the pause, it rejects with
+// ctx.Err() instead.
+func (p *Promise) ThenThrottle(fn func(interface{}) (interface{}, time.Duration, error)) *Promise {
+	return p.derive(func(value interface{}, err error) (interface{}, error) {
+		if err != nil || fn == nil {
+			return value, err
+		}
+		value, delay, err := fn(value)
+		if err != nil || delay <= 0 {
+			return value, err
+		}
+		ctx := p.context()
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return value, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+}
+
+// WithDeadlines settles the derived promise with p's outcome, calling onSoft
+// once if p is still pending after soft and rejecting with ErrTimeout if it is
+// still pending after hard. onSoft does not settle anything; its panics are
+// logged. Both timers stop as soon as the derived promise settles.
+func (p *Promise) WithDeadlines(soft, hard time.Duration, onSoft func()) *Promise {
+	return p.loop.coordinate(func() (interface{}, error) {
+		settled := watch(p)
+		softTimer := time.NewTimer(soft)
+		defer softTimer.Stop()
+		hardTimer := time.NewTimer(hard)
+		defer hardTimer.Stop()
+		for {
+			select {
+			case r := <-settled:
+				return r.Value, r.Err
+			case <-softTimer.C:
+				if onSoft == nil {
+					continue
+				}
+				if _, err := call(func() (interface{}, error) {
+					onSoft()
+					return nil, nil
+				}); err != nil {
+					p.loop.errorf("eventloop: %s: recovered panic in soft deadline callback: %v", p.ref(), err)
+				}
+			case <-hardTimer.C:
+				return nil, ErrTimeout
+			}
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package eventloop_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alob-mtc/go-promise/eventloop"
+	"github.com/alob-mtc/go-promise/eventloop/promisetest"
+)
+
+var errBoom = errors.New("boom")
+
+func TestThenMapNilPassesValueThrough(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	p := e.Async(func() (interface{}, error) {
+		return 42, nil
+	})
+	promisetest.AssertResolves(t, p.ThenMap(nil), 42)
+}
+
+func TestNilCatchStagePassesErrorThrough(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	p := e.Async(func() (interface{}, error) {
+		return nil, errBoom
+	})
+	promisetest.AssertRejects(t, p.CatchIs(errBoom, nil), errBoom)
+	promisetest.AssertRejects(t, p.ThenMap(nil), errBoom)
+}
+
+func TestThenAndCatchNilAreNoOps(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	p := e.Async(func() (interface{}, error) {
+		return nil, errBoom
+	})
+	caught := make(chan error, 1)
+	p.Then(nil).Catch(nil)
+	p.Then(nil).Catch(func(err error) {
+		caught <- err
+	})
+	if err := <-caught; err != errBoom {
+		t.Fatalf("Catch got %v, want %v", err, errBoom)
+	}
+}
+
+func TestRejectedChainSkipsThens(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	caught := make(chan error, 1)
+	e.RejectedChain(errBoom).ThenMap(func(v interface{}) (interface{}, error) {
+		t.Error("ThenMap ran on a rejected chain")
+		return v, nil
+	}).Then(func(interface{}) {
+		t.Error("Then ran on a rejected chain")
+	}).Catch(func(err error) {
+		caught <- err
+	})
+	if err := <-caught; err != errBoom {
+		t.Fatalf("Catch got %v, want %v", err, errBoom)
+	}
+}
+
+func TestThenChannelTakesFirstValue(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	p := e.Async(func() (interface{}, error) {
+		return 1, nil
+	}).ThenChannel(func(v interface{}) <-chan interface{} {
+		ch := make(chan interface{}, 2)
+		ch <- v.(int) + 1
+		ch <- v.(int) + 2
+		close(ch)
+		return ch
+	})
+	promisetest.AssertResolves(t, p, 2)
+}
+
+func TestThenChannelClosedEmpty(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	p := e.Async(func() (interface{}, error) {
+		return 1, nil
+	}).ThenChannel(func(interface{}) <-chan interface{} {
+		ch := make(chan interface{})
+		close(ch)
+		return ch
+	})
+	promisetest.AssertRejects(t, p, eventloop.ErrChannelClosed)
+}
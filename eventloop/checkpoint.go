@@ -0,0 +1,54 @@
+package eventloop
+
+import "context"
+
+// CheckpointStore keeps the last state an AsyncResumable worker saved, keyed
+// by the worker's id. It must outlive the process for work to resume after a
+// restart, e.g. by writing to a file or a database.
+type CheckpointStore interface {
+	Load(id string) (state interface{}, ok bool, err error)
+	Save(id string, state interface{}) error
+	Delete(id string) error
+}
+
+// Checkpoint is an AsyncResumable worker's handle on its saved progress.
+type Checkpoint struct {
+	id      string
+	store   CheckpointStore
+	state   interface{}
+	resumed bool
+}
+
+// Resumed returns the state saved by an earlier run, as loaded when this run
+// started; ok is false when there is nothing to resume from.
+func (cp Checkpoint) Resumed() (state interface{}, ok bool) {
+	return cp.state, cp.resumed
+}
+
+// Save records state as the point to resume from if this run does not finish.
+func (cp Checkpoint) Save(state interface{}) error {
+	return cp.store.Save(cp.id, state)
+}
+
+// AsyncResumable is AsyncContext for long work that survives restarts. fn
+// must cooperate: it saves its progress with cp.Save as it goes and, when
+// cp.Resumed reports a state, carries on from there instead of starting over;
+// the loop only loads and keeps the checkpoint. After the work resolves the
+// checkpoint for id is deleted, so the next run with id starts afresh. After a
+// rejection it is kept, for a later run to resume. A store error rejects.
+func (e *EventLoop) AsyncResumable(id string, fn func(ctx context.Context, cp Checkpoint) (interface{}, error), store CheckpointStore) *Promise {
+	return e.AsyncContext(context.Background(), func(ctx context.Context) (interface{}, error) {
+		state, ok, err := store.Load(id)
+		if err != nil {
+			return nil, err
+		}
+		value, err := fn(ctx, Checkpoint{id: id, store: store, state: state, resumed: ok})
+		if err != nil {
+			return nil, err
+		}
+		if err := store.Delete(id); err != nil {
+			return nil, err
+		}
+		return value, nil
+	})
+}
@@ -0,0 +1,101 @@
+package eventloop
+
+import (
+	"sync"
+	"time"
+)
+
+// ThenCoalesced calls fn with batches of the values the promise's result
+// yields, where a batch holds every value that arrived within window of the
+// first one in it. Which values that is depends on what p resolves with:
+//
+//   - a <-chan interface{} or chan interface{}: every value received until
+//     it is closed; the derived promise resolves with nil after the last
+//     batch.
+//   - a *Future: every later completion event (error events are skipped)
+//     until the future is closed, or the loop's context is done on a loop
+//     made by NewEventLoopWithContext; the listener is then removed and the
+//     derived promise resolves with nil after the last batch.
+//   - anything else: the value itself, as a batch of one.
+//
+// fn is never called concurrently with itself, and a panic in fn is logged
+// and dropped with its batch. A rejection of p skips fn.
+func (p *Promise) ThenCoalesced(window time.Duration, fn func([]interface{})) *Promise {
+	return p.derive(func(value interface{}, err error) (interface{}, error) {
+		if err != nil || fn == nil {
+			return value, err
+		}
+		b := &batcher{window: window, fn: fn, loop: p.loop}
+		switch src := receiveOnly(value).(type) {
+		case <-chan interface{}:
+			for v := range src {
+				b.add(v)
+			}
+			b.flush()
+		case *Future:
+			stop := src.listen(func(v interface{}, err error) {
+				if err == nil {
+					b.add(v)
+				}
+			})
+			var done <-chan struct{}
+			if p.loop.ctx != nil {
+				done = p.loop.ctx.Done()
+			}
+			select {
+			case <-src.closed:
+			case <-done:
+			}
+			stop()
+			b.flush()
+		default:
+			b.add(value)
+			b.flush()
+		}
+		return nil, nil
+	})
+}
+
+type batcher struct {
+	window time.Duration
+	fn     func([]interface{})
+	loop   *EventLoop
+
+	mu      sync.Mutex
+	pending []interface{}
+	timer   *time.Timer
+
+	flushMu sync.Mutex
+}
+
+func (b *batcher) add(v interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = append(b.pending, v)
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.window, b.flush)
+	}
+}
+
+// flush hands the pending batch, if any, to fn.
+func (b *batcher) flush() {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	b.mu.Lock()
+	batch := b.pending
+	b.pending = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+	if _, err := call(func() (interface{}, error) {
+		b.fn(batch)
+		return nil, nil
+	}); err != nil {
+		b.loop.errorf("eventloop: recovered panic in coalesced callback: %v", err)
+	}
+}
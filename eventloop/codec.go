@@ -0,0 +1,63 @@
+package eventloop
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrUnknownCodec = errors.New("eventloop: unknown codec")
+
+type codec struct {
+	enc func(interface{}) ([]byte, error)
+	dec func([]byte) (interface{}, error)
+}
+
+// RegisterCodec makes enc and dec available to Encode and Decode under name,
+// replacing any codec registered under it before.
+func (e *EventLoop) RegisterCodec(name string, enc func(interface{}) ([]byte, error), dec func([]byte) (interface{}, error)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.codecs == nil {
+		e.codecs = make(map[string]codec)
+	}
+	e.codecs[name] = codec{enc: enc, dec: dec}
+}
+
+func (e *EventLoop) codec(name string) (codec, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	c, ok := e.codecs[name]
+	if !ok {
+		return c, fmt.Errorf("%w: %q", ErrUnknownCodec, name)
+	}
+	return c, nil
+}
+
+// Encode resolves the derived promise with p's value encoded by the named
+// codec, as a []byte. An unknown name rejects with ErrUnknownCodec, and a
+// rejection of p is passed on.
+func (p *Promise) Encode(name string) *Promise {
+	return p.ThenMap(func(value interface{}) (interface{}, error) {
+		c, err := p.loop.codec(name)
+		if err != nil {
+			return nil, err
+		}
+		return c.enc(value)
+	})
+}
+
+// Decode is the reverse of Encode: p must resolve with a []byte, which the
+// named codec decodes; any other value rejects with ErrTypeMismatch.
+func (p *Promise) Decode(name string) *Promise {
+	return p.ThenMap(func(value interface{}) (interface{}, error) {
+		c, err := p.loop.codec(name)
+		if err != nil {
+			return nil, err
+		}
+		data, err := as[[]byte](value)
+		if err != nil {
+			return nil, err
+		}
+		return c.dec(data)
+	})
+}
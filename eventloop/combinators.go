@@ -0,0 +1,557 @@
+package eventloop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotAccepted    = errors.New("eventloop: no stage result was accepted")
+	ErrQuorumTooLarge = errors.New("eventloop: quorum larger than the number of promises")
+)
+
+type State int
+
+const (
+	Pending State = iota
+	Fulfilled
+	Rejected
+)
+
+func (s State) String() string {
+	switch s {
+	case Fulfilled:
+		return "fulfilled"
+	case Rejected:
+		return "rejected"
+	default:
+		return "pending"
+	}
+}
+
+// SettledResult is the outcome of a single promise in a batch.
+type SettledResult struct {
+	Index  int
+	Status State
+	Value  interface{}
+	Err    error
+}
+
+func settledResult(value interface{}, err error) SettledResult {
+	if err != nil {
+		return SettledResult{Status: Rejected, Err: err}
+	}
+	return SettledResult{Status: Fulfilled, Value: value}
+}
+
+// IndexedError is a rejection together with the index of the promise that
+// produced it.
+type IndexedError struct {
+	Index int
+	Err   error
+}
+
+// AggregateError reports every failure of a batch. Unwrap exposes the
+// underlying errors to errors.Is and errors.As.
+type AggregateError struct {
+	Errors []IndexedError
+}
+
+func (a *AggregateError) Error() string {
+	switch len(a.Errors) {
+	case 0:
+		return "eventloop: no promises rejected"
+	case 1:
+		return fmt.Sprintf("eventloop: 1 promise rejected: [%d] %v", a.Errors[0].Index, a.Errors[0].Err)
+	default:
+		return fmt.Sprintf("eventloop: %d promises rejected, first: [%d] %v", len(a.Errors), a.Errors[0].Index, a.Errors[0].Err)
+	}
+}
+
+func (a *AggregateError) Unwrap() []error {
+	errs := make([]error, len(a.Errors))
+	for i, e := range a.Errors {
+		errs[i] = e.Err
+	}
+	return errs
+}
+
+// watch awaits p on a new goroutine. The channel is buffered so the goroutine
+// exits as soon as p settles, even if nobody is left to receive.
+func watch(p *Promise) <-chan SettledResult {
+	settled := make(chan SettledResult, 1)
+	go func() {
+		value, err := p.loop.Await(p)
+		settled <- settledResult(value, err)
+	}()
+	return settled
+}
+
+// AsCompleted delivers each promise's outcome in the order they settle, with
+// Index set to its position in promises, and closes the channel once all have
+// settled. The channel is buffered for the whole batch, so no goroutine is
+// left blocked if the consumer stops reading early.
+func (e *EventLoop) AsCompleted(promises []*Promise) <-chan SettledResult {
+	out := make(chan SettledResult, len(promises))
+	var wg sync.WaitGroup
+	for i, p := range promises {
+		wg.Add(1)
+		go func(i int, p *Promise) {
+			defer wg.Done()
+			r := settledResult(e.Await(p))
+			r.Index = i
+			out <- r
+		}(i, p)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// OrderedAsCompleted is AsCompleted in input order, as a reorder buffer: each
+// outcome is delivered as soon as it and every outcome before it are known,
+// but the stream waits at most perItemDeadline for any one entry once it is
+// next in line. An entry that takes longer is skipped with Status Pending and
+// not delivered later. The channel is buffered for the whole batch and closed
+// after the last entry; skipped inputs still pending then are abandoned like
+// the losers of Some.
+func (e *EventLoop) OrderedAsCompleted(promises []*Promise, perItemDeadline time.Duration) <-chan SettledResult {
+	out := make(chan SettledResult, len(promises))
+	settled, abandon := outcomes(promises)
+	go func() {
+		defer close(out)
+		defer abandon()
+		results := make([]*SettledResult, len(promises))
+		timer := time.NewTimer(perItemDeadline)
+		defer timer.Stop()
+		for next := 0; next < len(promises); next++ {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(perItemDeadline)
+		wait:
+			for results[next] == nil {
+				select {
+				case r, ok := <-settled:
+					if !ok {
+						break wait
+					}
+					results[r.Index] = &r
+				case <-timer.C:
+					break wait
+				}
+			}
+			if r := results[next]; r != nil {
+				out <- *r
+			} else {
+				out <- SettledResult{Index: next, Status: Pending}
+			}
+		}
+	}()
+	return out
+}
+
+// settledOrStopped waits for p like Await, but gives up once stop is closed.
+func settledOrStopped(p *Promise, stop <-chan struct{}) (SettledResult, bool) {
+	p.trigger()
+	p.valueHandled.fire()
+	p.errHandled.fire()
+	select {
+	case <-p.settled:
+		return settledResult(p.loop.ownedResult(p), p.reason), true
+	case <-stop:
+		return SettledResult{}, false
+	}
+}
+
+// outcomes is AsCompleted for combinators that may stop before every input has
+// settled, and every such combinator uses it. Calling abandon, which is safe
+// more than once, applies the one policy for the inputs left behind: pending
+// promises made by AsyncCancelable are cancelled, and the goroutines waiting
+// on the others return, so nothing outlives the combinator. The outcomes of
+// abandoned inputs count as handled, as they did when they were awaited.
+func outcomes(promises []*Promise) (results <-chan SettledResult, abandon func()) {
+	out := make(chan SettledResult, len(promises))
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, p := range promises {
+		wg.Add(1)
+		go func(i int, p *Promise) {
+			defer wg.Done()
+			if r, ok := settledOrStopped(p, stop); ok {
+				r.Index = i
+				out <- r
+			}
+		}(i, p)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out, abandoner(promises, stop)
+}
+
+// abandoner returns the abandon function of outcomes: it closes stop and
+// cancels the promises still pending that can be cancelled.
+func abandoner(promises []*Promise, stop chan struct{}) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			for _, p := range promises {
+				if p.cancel != nil && p.State() == Pending {
+					p.cancel()
+				}
+			}
+		})
+	}
+}
+
+// AllWithDeadline resolves with the outcome of every promise once they have all
+// settled or d has elapsed, whichever comes first. Entries still running at the
+// deadline are reported as Pending and abandoned like the losers of Some.
+func (e *EventLoop) AllWithDeadline(promises []*Promise, d time.Duration) *Promise {
+	return e.coordinate(func() (interface{}, error) {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+
+		results := make([]SettledResult, len(promises))
+		for i := range results {
+			results[i].Index = i
+		}
+		settled, abandon := outcomes(promises)
+		defer abandon()
+		for range promises {
+			select {
+			case r := <-settled:
+				results[r.Index] = r
+			case <-timer.C:
+				return results, nil
+			}
+		}
+		return results, nil
+	})
+}
+
+// AllOrError waits for every promise, without failing fast, and resolves with
+// their values in input order if all of them resolved. Otherwise it rejects
+// with an *AggregateError holding every rejection in input order.
+func (e *EventLoop) AllOrError(promises []*Promise) *Promise {
+	return e.coordinate(func() (interface{}, error) {
+		results := make([]SettledResult, len(promises))
+		for r := range e.AsCompleted(promises) {
+			results[r.Index] = r
+		}
+		values := make([]interface{}, len(promises))
+		agg := &AggregateError{}
+		for i, r := range results {
+			if r.Err != nil {
+				agg.Errors = append(agg.Errors, IndexedError{Index: i, Err: r.Err})
+			}
+			values[i] = r.Value
+		}
+		if len(agg.Errors) > 0 {
+			return nil, agg
+		}
+		return values, nil
+	})
+}
+
+// AllBounded resolves with the values of promises in order, like Gather,
+// but waits on at most maxInFlightResults of them at a time: promise i is only
+// awaited once the results before i-maxInFlightResults have been taken in
+// order, so no more than that many finished results wait on a slow earlier one.
+// Promises that are already running keep running regardless; the bound holds
+// back Lazy promises, which only start once awaited, trading parallelism for
+// memory. It rejects with the first rejection in order, abandoning the rest
+// like the losers of Some. maxInFlightResults <= 0 waits on all of them at
+// once.
+func (e *EventLoop) AllBounded(promises []*Promise, maxInFlightResults int) *Promise {
+	return e.coordinate(func() (interface{}, error) {
+		window := maxInFlightResults
+		if window <= 0 {
+			window = len(promises)
+		}
+		values := make([]interface{}, len(promises))
+		pending := make([]chan SettledResult, len(promises))
+		stop := make(chan struct{})
+		abandon := abandoner(promises, stop)
+		defer abandon()
+		next := 0
+		// inputs never waited on are abandoned as well, their outcomes
+		// handled like those of the inputs that were
+		defer func() {
+			for _, p := range promises[next:] {
+				p.valueHandled.fire()
+				p.errHandled.fire()
+			}
+		}()
+		for i := range promises {
+			for ; next < len(promises) && next < i+window; next++ {
+				pending[next] = make(chan SettledResult, 1)
+				go func(p *Promise, settled chan<- SettledResult) {
+					if r, ok := settledOrStopped(p, stop); ok {
+						settled <- r
+					}
+				}(promises[next], pending[next])
+			}
+			r := <-pending[i]
+			pending[i] = nil
+			if r.Err != nil {
+				return nil, r.Err
+			}
+			values[i] = r.Value
+		}
+		return values, nil
+	})
+}
+
+// RaceContext settles with p's outcome, or rejects with ctx.Err() if ctx is
+// done first. p is then abandoned like the losers of Some: cancelled if it was
+// made by AsyncCancelable, otherwise left running with its result discarded.
+func (e *EventLoop) RaceContext(ctx context.Context, p *Promise) *Promise {
+	return e.coordinate(func() (interface{}, error) {
+		settled, abandon := outcomes([]*Promise{p})
+		defer abandon()
+		select {
+		case r := <-settled:
+			return r.Value, r.Err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+}
+
+// Gather waits for every promise and resolves with aggregate applied to their
+// values in input order. The first rejection rejects the result without
+// waiting for the rest, which are abandoned, and a panic in aggregate rejects
+// it too.
+func (e *EventLoop) Gather(promises []*Promise, aggregate func([]interface{}) (interface{}, error)) *Promise {
+	return e.coordinate(func() (interface{}, error) {
+		values, err := zip(e, promises...)
+		if err != nil {
+			return nil, err
+		}
+		return aggregate(values)
+	})
+}
+
+// CollectErrors resolves with the first k rejection reasons across promises
+// as a []error, as soon as k have been seen, or with fewer once every promise
+// has settled. k <= 0 collects them all. Promises still running when it
+// resolves are abandoned like the losers of Some.
+func (e *EventLoop) CollectErrors(promises []*Promise, k int) *Promise {
+	return e.coordinate(func() (interface{}, error) {
+		var errs []error
+		settled, abandon := outcomes(promises)
+		defer abandon()
+		for r := range settled {
+			if r.Err == nil {
+				continue
+			}
+			errs = append(errs, r.Err)
+			if len(errs) == k {
+				break
+			}
+		}
+		return errs, nil
+	})
+}
+
+// Some resolves with the values of the first n promises to resolve, in the
+// order they resolved, and rejects with an *AggregateError of every rejection
+// once fewer than n can still resolve. Either way the promises still running
+// are abandoned: those made by AsyncCancelable are cancelled, so quorum
+// members that are no longer needed stop, and the others keep running with
+// their outcomes dropped. Every combinator that stops waiting early treats its
+// remaining inputs this way. n <= 0 resolves straight away with no values,
+// and n > len(promises) rejects straight away with ErrQuorumTooLarge.
+func (e *EventLoop) Some(promises []*Promise, n int) *Promise {
+	return e.coordinate(func() (interface{}, error) {
+		settled, abandon := outcomes(promises)
+		defer abandon()
+		var values []interface{}
+		if n <= 0 {
+			return values, nil
+		}
+		if n > len(promises) {
+			return nil, fmt.Errorf("%w: need %d of %d", ErrQuorumTooLarge, n, len(promises))
+		}
+		agg := &AggregateError{}
+		for r := range settled {
+			if r.Err != nil {
+				agg.Errors = append(agg.Errors, IndexedError{Index: r.Index, Err: r.Err})
+				if len(promises)-len(agg.Errors) < n {
+					return nil, agg
+				}
+				continue
+			}
+			values = append(values, r.Value)
+			if len(values) == n {
+				return values, nil
+			}
+		}
+		return nil, agg
+	})
+}
+
+// Any is Some for a single value: it resolves with the first value among
+// promises, cancelling the rest the same way. With no promises it rejects
+// with ErrQuorumTooLarge.
+func (e *EventLoop) Any(promises []*Promise) *Promise {
+	some := e.Some(promises, 1)
+	return some.ThenMap(func(value interface{}) (interface{}, error) {
+		return value.([]interface{})[0], nil
+	})
+}
+
+// LeaderResult identifies the candidate that won an Elect.
+type LeaderResult struct {
+	Index int
+	Value interface{}
+}
+
+// Elect runs every candidate concurrently, each typically trying to acquire
+// the same lock, and resolves with a LeaderResult for the first to succeed.
+// The other candidates' promises are then cancelled, as in Any, and any later
+// success among them is dropped; the candidates take no context, so a
+// candidate that acquires the lock after losing must release it itself. If
+// every candidate fails it rejects with an *AggregateError.
+func (e *EventLoop) Elect(candidates []func() (interface{}, error)) *Promise {
+	promises := make([]*Promise, len(candidates))
+	for i, candidate := range candidates {
+		candidate := candidate
+		promises[i], _ = e.AsyncCancelable(func(context.Context) (interface{}, error) {
+			return candidate()
+		}, func() {})
+	}
+	return e.coordinate(func() (interface{}, error) {
+		settled, abandon := outcomes(promises)
+		defer abandon()
+		agg := &AggregateError{}
+		for r := range settled {
+			if r.Err == nil {
+				return LeaderResult{Index: r.Index, Value: r.Value}, nil
+			}
+			agg.Errors = append(agg.Errors, IndexedError{Index: r.Index, Err: r.Err})
+		}
+		return nil, agg
+	})
+}
+
+// Sink consumes the values DrainInto delivers. Accept is never called
+// concurrently, so it need not be safe for concurrent use.
+type Sink interface {
+	Accept(value interface{}) error
+}
+
+// DrainInto hands each promise's value to sink as it resolves, one call at a
+// time, and resolves with the number of values delivered once all have been.
+// The first rejection, or the first error or panic from Accept, rejects and
+// abandons the promises not yet delivered, like the losers of Some.
+func (e *EventLoop) DrainInto(promises []*Promise, sink Sink) *Promise {
+	return e.coordinate(func() (interface{}, error) {
+		settled, abandon := outcomes(promises)
+		defer abandon()
+		n := 0
+		for r := range settled {
+			if r.Err != nil {
+				return nil, r.Err
+			}
+			if err := sink.Accept(r.Value); err != nil {
+				return nil, err
+			}
+			n++
+		}
+		return n, nil
+	})
+}
+
+// Waterfall runs fns one after another, each with the previous result (nil for
+// the first), and resolves with the first result stopOn accepts, skipping the
+// stages after it. An error rejects straight away; if every stage runs without
+// an accepted result it rejects with ErrNotAccepted.
+func (e *EventLoop) Waterfall(fns []func(prev interface{}) (interface{}, error), stopOn func(interface{}) bool) *Promise {
+	return e.coordinate(func() (interface{}, error) {
+		var prev interface{}
+		for _, fn := range fns {
+			fn := fn
+			value, err := e.Await(e.Async(func() (interface{}, error) {
+				return fn(prev)
+			}))
+			if err != nil {
+				return nil, err
+			}
+			if stopOn(value) {
+				return value, nil
+			}
+			prev = value
+		}
+		return nil, ErrNotAccepted
+	})
+}
+
+// Trampoline runs step on initial, then on each value it returns, until step
+// reports done, and resolves with that last value. Every step runs in a loop
+// inside one Async worker, holding a single slot, so an async recursion of
+// any depth costs no more goroutines or promises than a single step. An error
+// or panic from step rejects.
+func (e *EventLoop) Trampoline(initial interface{}, step func(interface{}) (next interface{}, done bool, err error)) *Promise {
+	return e.Async(func() (interface{}, error) {
+		value := initial
+		for {
+			next, done, err := step(value)
+			if err != nil {
+				return nil, err
+			}
+			if done {
+				return next, nil
+			}
+			value = next
+		}
+	})
+}
+
+// AllOrNothing resolves with every value in input order if all promises
+// resolve. If any rejects, it waits for the rest to settle, calls compensate
+// for each promise that resolved, most recently settled first, and then
+// rejects with the first rejection. A panic in compensate is logged and the
+// remaining compensations still run.
+func (e *EventLoop) AllOrNothing(promises []*Promise, compensate func(index int, value interface{})) *Promise {
+	return e.coordinate(func() (interface{}, error) {
+		values := make([]interface{}, len(promises))
+		var succeeded []int
+		var firstErr error
+		for r := range e.AsCompleted(promises) {
+			if r.Err != nil {
+				if firstErr == nil {
+					firstErr = r.Err
+				}
+				continue
+			}
+			values[r.Index] = r.Value
+			succeeded = append(succeeded, r.Index)
+		}
+		if firstErr == nil {
+			return values, nil
+		}
+		for i := len(succeeded) - 1; i >= 0; i-- {
+			index := succeeded[i]
+			if _, err := call(func() (interface{}, error) {
+				compensate(index, values[index])
+				return nil, nil
+			}); err != nil {
+				e.errorf("eventloop: recovered panic compensating promise %d: %v", promises[index].id, err)
+			}
+		}
+		return nil, firstErr
+	})
+}
@@ -0,0 +1,125 @@
+package eventloop_test
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/alob-mtc/go-promise/eventloop"
+	"github.com/alob-mtc/go-promise/eventloop/promisetest"
+)
+
+// waitGoroutines fails the test unless the goroutine count drops back to at
+// most want within a second.
+func waitGoroutines(t *testing.T, want int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > want {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left running, want at most %d", runtime.NumGoroutine(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCombinatorsAbandonLosers(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		winner  error
+		combine func(t *testing.T, e *eventloop.EventLoop, promises []*eventloop.Promise) *eventloop.Promise
+	}{
+		{"Some", nil, func(t *testing.T, e *eventloop.EventLoop, promises []*eventloop.Promise) *eventloop.Promise {
+			return e.Some(promises, 1)
+		}},
+		{"Any", nil, func(t *testing.T, e *eventloop.EventLoop, promises []*eventloop.Promise) *eventloop.Promise {
+			return e.Any(promises)
+		}},
+		{"CollectErrors", errBoom, func(t *testing.T, e *eventloop.EventLoop, promises []*eventloop.Promise) *eventloop.Promise {
+			return e.CollectErrors(promises, 1)
+		}},
+		{"Gather", errBoom, func(t *testing.T, e *eventloop.EventLoop, promises []*eventloop.Promise) *eventloop.Promise {
+			return e.Gather(promises, func(values []interface{}) (interface{}, error) {
+				return values, nil
+			})
+		}},
+		{"AllBounded", errBoom, func(t *testing.T, e *eventloop.EventLoop, promises []*eventloop.Promise) *eventloop.Promise {
+			return e.AllBounded(promises, 0)
+		}},
+		{"AllWithDeadline", nil, func(t *testing.T, e *eventloop.EventLoop, promises []*eventloop.Promise) *eventloop.Promise {
+			return e.AllWithDeadline(promises, 10*time.Millisecond)
+		}},
+		{"RaceContext", nil, func(t *testing.T, e *eventloop.EventLoop, promises []*eventloop.Promise) *eventloop.Promise {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			t.Cleanup(cancel)
+			return e.RaceContext(ctx, promises[1])
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := eventloop.NewEventLoopWithCapacity(0)
+			release := make(chan struct{})
+			defer close(release)
+			winner := e.Async(func() (interface{}, error) {
+				return 1, tc.winner
+			})
+			cancelable, _ := e.AsyncCancelable(func(ctx context.Context) (interface{}, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}, func() {})
+			plain := e.Async(func() (interface{}, error) {
+				<-release
+				return nil, nil
+			})
+			promisetest.AwaitForTest(t, winner, promisetest.Timeout)
+			// the cancelable input's goroutines all end once it is cancelled
+			baseline := runtime.NumGoroutine() - 2
+			promisetest.AwaitForTest(t, tc.combine(t, e, []*eventloop.Promise{winner, cancelable, plain}), promisetest.Timeout)
+			promisetest.AssertRejects(t, cancelable, context.Canceled)
+			waitGoroutines(t, baseline)
+		})
+	}
+}
+
+func TestSomeQuorumTooLarge(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	release := make(chan struct{})
+	defer close(release)
+	pending := e.Async(func() (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+	p := e.Some([]*eventloop.Promise{pending}, 2)
+	if _, err := promisetest.AwaitForTest(t, p, 100*time.Millisecond); !errors.Is(err, eventloop.ErrQuorumTooLarge) {
+		t.Fatalf("Some got %v, want %v", err, eventloop.ErrQuorumTooLarge)
+	}
+}
+
+func TestAllWithDeadlineReportsPending(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	release := make(chan struct{})
+	defer close(release)
+	done := e.Async(func() (interface{}, error) {
+		return 1, nil
+	})
+	never := e.Async(func() (interface{}, error) {
+		<-release
+		return 2, nil
+	})
+	const d = 20 * time.Millisecond
+	start := time.Now()
+	v, err := promisetest.AwaitForTest(t, e.AllWithDeadline([]*eventloop.Promise{done, never}, d), promisetest.Timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < d {
+		t.Fatalf("AllWithDeadline resolved after %s, before the %s deadline", elapsed, d)
+	}
+	results := v.([]eventloop.SettledResult)
+	if r := results[0]; r.Status != eventloop.Fulfilled || r.Value != 1 {
+		t.Fatalf("results[0] = %+v, want Fulfilled with 1", r)
+	}
+	if r := results[1]; r.Index != 1 || r.Status != eventloop.Pending || r.Value != nil || r.Err != nil {
+		t.Fatalf("results[1] = %+v, want Pending", r)
+	}
+}
@@ -0,0 +1,58 @@
+package eventloop
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// CommandResult is the value an AsyncCommand promise resolves with.
+type CommandResult struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+}
+
+// CommandError rejects an AsyncCommand promise. ExitCode is -1 if the command
+// did not start or was killed.
+type CommandError struct {
+	Name     string
+	ExitCode int
+	Stderr   []byte
+	Err      error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("eventloop: command %s: exit code %d: %v", e.Name, e.ExitCode, e.Err)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// AsyncCommand runs the named program and resolves with its output once it
+// exits with status 0. Any other exit, or a failure to start, rejects with a
+// *CommandError. When ctx is done the process is killed and waited for, so
+// nothing is left behind.
+func (e *EventLoop) AsyncCommand(ctx context.Context, name string, args ...string) *Promise {
+	return e.AsyncContext(ctx, func(ctx context.Context) (interface{}, error) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			code := -1
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				code = exitErr.ExitCode()
+			}
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				err = ctxErr
+			}
+			return nil, &CommandError{Name: name, ExitCode: code, Stderr: stderr.Bytes(), Err: err}
+		}
+		return CommandResult{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}, nil
+	})
+}
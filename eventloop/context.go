@@ -0,0 +1,120 @@
+package eventloop
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Both wrap context.Canceled, so code that only checks for cancellation keeps
+// working.
+var (
+	ErrPromiseFulfilled = fmt.Errorf("%w: promise fulfilled", context.Canceled)
+	ErrPromiseRejected  = fmt.Errorf("%w: promise rejected", context.Canceled)
+)
+
+type promiseContext struct {
+	context.Context
+
+	mu   sync.Mutex
+	done chan struct{}
+	err  error
+}
+
+func (c *promiseContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *promiseContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// cancel sets Err and closes Done together, the first time only.
+func (c *promiseContext) cancel(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+		close(c.done)
+	}
+}
+
+// NewEventLoopWithContext returns a standalone loop bound to ctx. Once ctx is
+// done, every pending promise rejects with ctx.Err(), so its Then callbacks are
+// skipped and its Catch callbacks run, and promises created afterwards reject
+// with it straight away. Work already running keeps going, but its result is
+// discarded.
+func NewEventLoopWithContext(ctx context.Context) *EventLoop {
+	e := &EventLoop{promiseQueue: []*Promise{}, ctx: ctx}
+	go func() {
+		<-ctx.Done()
+		mode := e.deliveryMode()
+		for _, p := range e.queue() {
+			if p.State() == Pending {
+				go e.promiseRecovery(p, mode)(nil, ctx.Err())
+			}
+		}
+	}()
+	return e
+}
+
+// ContextFromPromise returns a context that is cancelled when p settles. Its
+// Err is ErrPromiseFulfilled or ErrPromiseRejected depending on the outcome,
+// or context.Canceled if the CancelFunc ran first, which also stops watching
+// p.
+func (e *EventLoop) ContextFromPromise(p *Promise) (context.Context, context.CancelFunc) {
+	ctx := &promiseContext{Context: context.Background(), done: make(chan struct{})}
+	stop := make(chan struct{})
+	var once sync.Once
+	go func() {
+		r, ok := settledOrStopped(p, stop)
+		if !ok {
+			return
+		}
+		if r.Err != nil {
+			ctx.cancel(ErrPromiseRejected)
+		} else {
+			ctx.cancel(ErrPromiseFulfilled)
+		}
+	}()
+	return ctx, func() {
+		once.Do(func() {
+			close(stop)
+		})
+		ctx.cancel(context.Canceled)
+	}
+}
+
+// AsyncContext is Async with a context: fn receives ctx, and ctx travels down
+// the chain so ThenCtx stages see the same values and cancellation. If ctx is
+// already done when the worker starts, fn is skipped and the promise rejects
+// with ctx.Err().
+func (e *EventLoop) AsyncContext(ctx context.Context, fn func(ctx context.Context) (interface{}, error)) *Promise {
+	p := e.add(&Promise{ctx: ctx})
+	return e.runAsync(p, func() (interface{}, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return fn(context.WithValue(context.WithValue(ctx, workerKey{}, e), awaiterKey{}, p))
+	})
+}
+
+// ThenCtx is ThenMap with the context of the chain, as given to AsyncContext,
+// or context.Background() if there is none. A rejection of p skips fn.
+func (p *Promise) ThenCtx(fn func(ctx context.Context, value interface{}) (interface{}, error)) *Promise {
+	return p.derive(func(value interface{}, err error) (interface{}, error) {
+		if err != nil || fn == nil {
+			return value, err
+		}
+		return fn(p.context(), value)
+	})
+}
+
+func (p *Promise) context() context.Context {
+	if p.ctx == nil {
+		return context.Background()
+	}
+	return p.ctx
+}
@@ -0,0 +1,57 @@
+package eventloop_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alob-mtc/go-promise/eventloop"
+	"github.com/alob-mtc/go-promise/eventloop/promisetest"
+)
+
+type traceKey struct{}
+
+func TestContextValuesReachThenCtx(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	ctx := context.WithValue(context.Background(), traceKey{}, "trace-1")
+	p := e.AsyncContext(ctx, func(ctx context.Context) (interface{}, error) {
+		return ctx.Value(traceKey{}), nil
+	}).ThenMap(func(v interface{}) (interface{}, error) {
+		return v, nil
+	}).ThenCtx(func(ctx context.Context, v interface{}) (interface{}, error) {
+		return []interface{}{v, ctx.Value(traceKey{})}, nil
+	})
+	promisetest.AssertResolves(t, p, []interface{}{"trace-1", "trace-1"})
+}
+
+func TestNewEventLoopWithContextCancelsPending(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	e := eventloop.NewEventLoopWithContext(ctx)
+	block := make(chan struct{})
+	defer close(block)
+	promises := make([]*eventloop.Promise, 3)
+	for i := range promises {
+		promises[i] = e.Async(func() (interface{}, error) {
+			<-block
+			return nil, nil
+		})
+	}
+	derived := promises[0].ThenMap(func(v interface{}) (interface{}, error) {
+		t.Error("ThenMap ran after the loop's context was cancelled")
+		return v, nil
+	})
+	caught := make(chan error, 1)
+	promises[1].Catch(func(err error) {
+		caught <- err
+	})
+	cancel()
+	for _, p := range append(promises, derived) {
+		promisetest.AssertRejects(t, p, context.Canceled)
+	}
+	if err := <-caught; err != context.Canceled {
+		t.Fatalf("Catch got %v, want %v", err, context.Canceled)
+	}
+	promisetest.AssertRejects(t, e.Async(func() (interface{}, error) {
+		t.Error("Async ran after the loop's context was cancelled")
+		return nil, nil
+	}), context.Canceled)
+}
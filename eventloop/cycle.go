@@ -0,0 +1,73 @@
+package eventloop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrCycleDetected = errors.New("eventloop: await cycle detected")
+
+type awaiterKey struct{}
+
+// AwaitContext is Await for AsyncContext workers, called with the ctx the
+// worker received: if the promise cannot settle before the worker's own
+// promise does, because it is derived from it or awaits it in turn, it
+// returns an error wrapping ErrCycleDetected that lists the promises
+// involved, each waiting on the next, instead of deadlocking. Dependencies
+// through derived stages and through other AwaitContext calls are followed;
+// an Await elsewhere is not seen. It also gives up with ctx.Err() once ctx is
+// done. With any other ctx it only adds the ctx check to Await.
+func (e *EventLoop) AwaitContext(ctx context.Context, p *Promise) (interface{}, error) {
+	if w, ok := ctx.Value(awaiterKey{}).(*Promise); ok && w.loop != nil {
+		w.loop.awaitMu.Lock()
+		if path := dependsOn(p, w); path != nil {
+			w.loop.awaitMu.Unlock()
+			refs := make([]string, len(path)+1)
+			refs[0] = w.ref()
+			for i, q := range path {
+				refs[i+1] = q.ref()
+			}
+			return nil, fmt.Errorf("%w: %s", ErrCycleDetected, strings.Join(refs, " -> "))
+		}
+		w.awaiting = p
+		w.loop.awaitMu.Unlock()
+		defer func() {
+			w.loop.awaitMu.Lock()
+			w.awaiting = nil
+			w.loop.awaitMu.Unlock()
+		}()
+	}
+	select {
+	case r := <-watch(p):
+		return r.Value, r.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// dependsOn returns the chain of promises from p to w that p waits on before
+// it can settle, ending with w, or nil if there is none. Every pending
+// promise waits on its parent and on the promise it is awaiting, if any;
+// awaitMu is held.
+func dependsOn(p, w *Promise) []*Promise {
+	seen := make(map[*Promise]bool)
+	var walk func(q *Promise) []*Promise
+	walk = func(q *Promise) []*Promise {
+		if q == nil || seen[q] || q.State() != Pending {
+			return nil
+		}
+		if q == w {
+			return []*Promise{q}
+		}
+		seen[q] = true
+		for _, next := range []*Promise{q.parent, q.awaiting} {
+			if path := walk(next); path != nil {
+				return append([]*Promise{q}, path...)
+			}
+		}
+		return nil
+	}
+	return walk(p)
+}
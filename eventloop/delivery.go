@@ -0,0 +1,38 @@
+package eventloop
+
+import "time"
+
+// DeliveryMode controls what the worker goroutine does after an Async result
+// settles. The outcome is always kept on the promise, so late consumers receive
+// it in every mode; the mode only decides how long the worker waits for a
+// consumer and whether an unconsumed outcome is reported.
+type DeliveryMode int
+
+const (
+	// DeliveryTimeoutDrop waits up to DeliveryTimeout for a consumer and then
+	// reports the outcome as unconsumed to the logger (and to strict mode for
+	// rejections). This is the default.
+	DeliveryTimeoutDrop DeliveryMode = iota
+	// DeliveryBlock keeps the worker goroutine until a consumer is attached,
+	// however long that takes.
+	DeliveryBlock
+	// DeliveryBuffer releases the worker goroutine immediately and never
+	// reports unconsumed outcomes.
+	DeliveryBuffer
+)
+
+// DeliveryTimeout is how long DeliveryTimeoutDrop waits for a consumer.
+const DeliveryTimeout = time.Second
+
+// SetDeliveryMode changes the delivery mode for promises created afterwards.
+func (e *EventLoop) SetDeliveryMode(mode DeliveryMode) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.delivery = mode
+}
+
+func (e *EventLoop) deliveryMode() DeliveryMode {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.delivery
+}
@@ -0,0 +1,88 @@
+package eventloop
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrResultTooLarge = errors.New("eventloop: result is too large")
+
+// SetResultEncoder sets how WriteTo turns a value into bytes; a nil enc
+// restores the default, json.Marshal.
+func (e *EventLoop) SetResultEncoder(enc func(value interface{}) ([]byte, error)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.encoder = enc
+}
+
+func (e *EventLoop) resultEncoder() func(value interface{}) ([]byte, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.encoder == nil {
+		return json.Marshal
+	}
+	return e.encoder
+}
+
+// WriteTo awaits p and writes its value to w, encoded with the loop's result
+// encoder (JSON by default), so a Promise is an io.WriterTo. A rejection is
+// returned as is and nothing is written.
+func (p *Promise) WriteTo(w io.Writer) (int64, error) {
+	value, err := p.loop.Await(p)
+	if err != nil {
+		return 0, err
+	}
+	b, err := p.loop.resultEncoder()(value)
+	if err != nil {
+		return 0, fmt.Errorf("eventloop: promise %d: encode result: %w", p.id, err)
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// SetMaxResultBytes rejects a promise with ErrResultTooLarge instead of
+// resolving it when its value is more than n bytes, guarding against a worker
+// that returns something enormous. Values are measured with the sizer set by
+// SetResultSizer or, without one, by encoding them with the result encoder,
+// which is accurate but not cheap. A value that cannot be sized, because
+// encoding fails or the sizer panics, is let through. n <= 0 turns the check
+// off, which is the default.
+func (e *EventLoop) SetMaxResultBytes(n int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.maxResult = n
+}
+
+// SetResultSizer sets how SetMaxResultBytes measures a value, e.g. a cheap
+// estimate from a slice's length; a nil sizer restores encoding the value.
+func (e *EventLoop) SetResultSizer(sizer func(value interface{}) int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sizer = sizer
+}
+
+func (e *EventLoop) checkResultSize(value interface{}) error {
+	e.mu.RLock()
+	limit, sizer := e.maxResult, e.sizer
+	e.mu.RUnlock()
+	if limit <= 0 {
+		return nil
+	}
+	size, err := call(func() (interface{}, error) {
+		if sizer != nil {
+			return sizer(value), nil
+		}
+		b, err := e.resultEncoder()(value)
+		return len(b), err
+	})
+	if err != nil {
+		e.debugf("eventloop: cannot size result: %v", err)
+		return nil
+	}
+	if n := size.(int); n > limit {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrResultTooLarge, n, limit)
+	}
+	return nil
+}
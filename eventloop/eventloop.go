@@ -2,6 +2,7 @@ package eventloop
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
@@ -9,11 +10,45 @@ import (
 )
 
 var once sync.Once
+
+var ErrNoHandler = errors.New("eventloop: no function registered for future event")
 var GlobalEventLoop *EventLoop
 
 type EventLoop struct {
+	queueMu      sync.Mutex
 	promiseQueue []*Promise
-	size         uint64
+	lastID       uint64 // IDs only ever grow; queue length is tracked by promiseQueue
+	settles      uint32 // promises settled so far, for SetStallTimeout
+
+	mu          sync.RWMutex
+	logger      Logger
+	strict      bool
+	delivery    DeliveryMode
+	stall       time.Duration
+	slow        time.Duration
+	onSlow      func(p *Promise, elapsed time.Duration)
+	timeout     time.Duration
+	tracer      Tracer
+	serial      bool
+	validator   func(value interface{}) error
+	middleware  []func(next func(error) error) func(error) error
+	recorder    Recorder
+	recordSeq   map[string]int
+	encoder     func(value interface{}) ([]byte, error)
+	copier      func(value interface{}) interface{}
+	maxResult   int
+	sizer       func(value interface{}) int
+	idempotency IdempotencyStore
+	codecs      map[string]codec
+	attempts    bool // SetAttemptResults
+	fatalPanic  func(recovered interface{}) bool
+
+	cache     asyncCache
+	sched     scheduler
+	callbacks callbackQueue
+	awaitMu   sync.Mutex // guards Promise.awaiting
+
+	ctx context.Context // set by NewEventLoopWithContext
 }
 
 func Init() {
@@ -22,76 +57,409 @@ func Init() {
 	})
 }
 
+// NewEventLoopWithCapacity returns a standalone loop whose queue has room for
+// n promises before it needs to grow.
+func NewEventLoopWithCapacity(n int) *EventLoop {
+	return &EventLoop{promiseQueue: make([]*Promise, 0, n)}
+}
+
 func GetGlobalEventLoop() *EventLoop {
 	return GlobalEventLoop
 }
 
 func (e *EventLoop) Await(currentP *Promise) (interface{}, error) {
-	defer currentP.Done()
-	currentP.RegisterHandler()
+	currentP.trigger()
+	currentP.addHandler()
+	defer currentP.handlerDone()
+	currentP.valueHandled.fire()
+	currentP.errHandled.fire()
+	<-currentP.settled
+	return e.ownedResult(currentP), currentP.reason
+}
+
+// AwaitPolling is Await for callers that must not block on a channel: it
+// checks TryResult, sleeping initial between checks and doubling the sleep up
+// to max. It is a last resort, as it adds up to max of latency and burns
+// wakeups compared with Await, which should be used wherever possible. An
+// initial below a millisecond starts at one.
+func (e *EventLoop) AwaitPolling(p *Promise, initial, max time.Duration) (interface{}, error) {
+	if initial < time.Millisecond {
+		initial = time.Millisecond
+	}
+	p.trigger()
+	p.addHandler()
+	defer p.handlerDone()
+	p.valueHandled.fire()
+	p.errHandled.fire()
+	for d := initial; ; {
+		if value, ok, err := p.TryResult(); ok {
+			return value, err
+		}
+		time.Sleep(d)
+		if d *= 2; d > max {
+			d = max
+		}
+	}
+}
+
+// AwaitInterruptible is Await that gives up when interrupt receives a value or
+// is closed, reporting completed false. The promise is not cancelled: it keeps
+// running and settles as usual, without anything left blocked on it.
+func (e *EventLoop) AwaitInterruptible(p *Promise, interrupt <-chan struct{}) (value interface{}, completed bool, err error) {
 	select {
-	case err := <-currentP.errChan:
-		return nil, err
-	case rev := <-currentP.rev:
-		return rev, nil
+	case r := <-watch(p):
+		return r.Value, true, r.Err
+	case <-interrupt:
+		return nil, false, nil
 	}
 }
 
 func (e *EventLoop) Async(fn func() (interface{}, error)) *Promise {
-	resultChan := make(chan interface{})
-	errChan := make(chan error)
-	p := e.newPromise(resultChan, errChan)
-	go func() {
-		recoveryHandler := promiseRecovery(resultChan, errChan)
-		defer func() {
-			if r := recover(); r != nil {
-				switch x := r.(type) {
-				case error:
-					recoveryHandler(nil, x)
-				default:
-					recoveryHandler(nil, fmt.Errorf("%v", x))
+	return e.async("", fn)
+}
+
+// AsyncNamed is Async with a name that shows up in diagnostics.
+func (e *EventLoop) AsyncNamed(name string, fn func() (interface{}, error)) *Promise {
+	return e.async(name, fn)
+}
+
+func (e *EventLoop) async(name string, fn func() (interface{}, error)) *Promise {
+	return e.runAsync(e.newPromise(name), fn)
+}
+
+// runAsync starts fn as p's Async worker, under the default timeout if one is
+// set.
+func (e *EventLoop) runAsync(p *Promise, fn func() (interface{}, error)) *Promise {
+	e.run(p, true, e.recorded(p, fn))
+	if d := e.defaultTimeout(); d > 0 {
+		p.WithTimeout(d)
+	}
+	return p
+}
+
+// coordinate is Async for promises that only wait on other promises. They
+// never take a worker slot, so combinators cannot deadlock a full loop.
+func (e *EventLoop) coordinate(fn func() (interface{}, error)) *Promise {
+	return e.start("", false, fn)
+}
+
+// Lazy returns a promise whose fn does not run until the first Await, Then or
+// Catch on it, whereas Async starts fn straight away. Any number of consumers
+// still share a single run. Waiting on Settled alone does not start it, and a
+// lazy promise that is never consumed never runs.
+func (e *EventLoop) Lazy(fn func() (interface{}, error)) *Promise {
+	p := e.newPromise("")
+	p.lazy = func() {
+		e.run(p, true, fn)
+	}
+	return p
+}
+
+func (e *EventLoop) start(name string, bounded bool, fn func() (interface{}, error)) *Promise {
+	p := e.newPromise(name)
+	e.run(p, bounded, fn)
+	return p
+}
+
+func (e *EventLoop) run(p *Promise, bounded bool, fn func() (interface{}, error)) {
+	mode := e.deliveryMode()
+	if e.ctx != nil && e.ctx.Err() != nil {
+		go e.promiseRecovery(p, mode)(nil, e.ctx.Err())
+		return
+	}
+	task := func() {
+		// only fn is guarded, so a panic from the handler itself (strict
+		// mode) is not mistaken for a second outcome
+		end := e.startSpan(p, 0)
+		result, err := func() (result interface{}, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					if e.isFatalPanic(r) {
+						panic(r)
+					}
+					e.errorf("eventloop: %s: recovered panic: %v", p.ref(), r)
+					err = recoveredError(r)
 				}
-			}
+			}()
+			return withLabels(p, fn)
 		}()
-		result, err := fn()
-		recoveryHandler(result, err)
+		end(err)
+		err, ok := e.settleOutcome(p, result, err)
+		if !ok || mode == DeliveryBuffer {
+			return
+		}
+		if bounded {
+			// the worker slot is freed once p has settled, not once its
+			// outcome has been consumed
+			go e.deliver(p, mode, err)
+		} else {
+			e.deliver(p, mode, err)
+		}
+	}
+	if !bounded {
+		go task()
+	} else if !e.sched.submit(p.tag, task) {
+		go e.promiseRecovery(p, mode)(nil, ErrQueueFull)
+	}
+}
+
+func recoveredError(r interface{}) error {
+	switch x := r.(type) {
+	case error:
+		return x
+	default:
+		return fmt.Errorf("%v", x)
+	}
+}
+
+// PanicError is a panic recovered from a Then or Catch callback. Stage is the
+// 1-based position of the callback among the stages attached to the promise.
+type PanicError struct {
+	PromiseID uint64
+	Name      string
+	Stage     int
+	Value     interface{}
+}
+
+func (e *PanicError) Error() string {
+	if e.Name != "" {
+		return fmt.Sprintf("eventloop: promise %d (%s) stage %d panicked: %v", e.PromiseID, e.Name, e.Stage, e.Value)
+	}
+	return fmt.Sprintf("eventloop: promise %d stage %d panicked: %v", e.PromiseID, e.Stage, e.Value)
+}
+
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// call runs fn on the current goroutine, turning a panic into an error the
+// same way Async does.
+func call(fn func() (interface{}, error)) (value interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoveredError(r)
+		}
 	}()
+	return fn()
+}
+
+// RejectedChain returns a promise that is already rejected with err: every
+// Then attached to it is skipped and the eventual Catch receives err.
+func (e *EventLoop) RejectedChain(err error) *Promise {
+	return e.coordinate(func() (interface{}, error) {
+		return nil, err
+	})
+}
+
+// FromWaitGroup returns a promise that resolves with nil once wg.Wait returns.
+// The usual WaitGroup rules still apply: Add must happen before the call, and
+// a counter that never reaches zero leaves the promise pending forever.
+func (e *EventLoop) FromWaitGroup(wg *sync.WaitGroup) *Promise {
+	return e.coordinate(func() (interface{}, error) {
+		wg.Wait()
+		return nil, nil
+	})
+}
+
+// Adopt returns a promise on e that settles with the outcome of foreign, a
+// promise from another loop, so that e's Main and combinators see it like
+// one of their own. Adopting consumes foreign's outcome. If the adopted
+// promise settles first, e.g. through WithTimeout, it stops waiting on
+// foreign and nothing is left behind.
+func (e *EventLoop) Adopt(foreign *Promise) *Promise {
+	p := e.newPromise("")
+	e.run(p, false, func() (interface{}, error) {
+		foreign.trigger()
+		select {
+		case <-foreign.settled:
+		case <-p.settled:
+			return nil, nil
+		}
+		value, err := foreign.loop.Await(foreign)
+		if err == nil && foreign.stopped {
+			return Stop(value), nil
+		}
+		return value, err
+	})
 	return p
 }
 
-func promiseRecovery(resultChan chan interface{}, errChan chan error) func(result interface{}, err error) {
+func (e *EventLoop) promiseRecovery(p *Promise, mode DeliveryMode) func(result interface{}, err error) {
 	return func(result interface{}, err error) {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
-		defer cancel()
-		if err != nil {
-			select {
-			case errChan <- err:
-			case <-ctx.Done():
-			}
-			return
+		if err, ok := e.settleOutcome(p, result, err); ok {
+			e.deliver(p, mode, err)
 		}
+	}
+}
 
-		select {
-		case resultChan <- result:
-		case <-ctx.Done():
+// settleOutcome validates result and settles p with it, returning the error
+// p settled with and whether this call settled p.
+func (e *EventLoop) settleOutcome(p *Promise, result interface{}, err error) (error, bool) {
+	if err == nil {
+		err = e.validate(result)
+	}
+	if err == nil {
+		err = e.checkResultSize(result)
+	}
+	if !p.settle(result, err) {
+		// the work and a WithTimeout deadline raced
+		e.debugf("eventloop: %s: already settled, outcome discarded", p.ref())
+		if err == nil && p.discard != nil {
+			p.discard(result)
 		}
+		return err, false
 	}
+	return err, true
 }
 
+// deliver waits, as mode says, for the settled p's outcome to be consumed,
+// reporting a rejection nobody handled.
+func (e *EventLoop) deliver(p *Promise, mode DeliveryMode, err error) {
+	handled := p.valueHandled
+	if err != nil {
+		handled = p.errHandled
+	}
+	switch mode {
+	case DeliveryBuffer:
+		return
+	case DeliveryBlock:
+		<-handled.ch
+		return
+	}
+
+	timer := time.NewTimer(DeliveryTimeout)
+	defer timer.Stop()
+	select {
+	case <-handled.ch:
+	case <-timer.C:
+		if err == nil {
+			e.debugf("eventloop: %s: result not consumed", p.ref())
+			return
+		}
+		e.errorf("eventloop: %s: unhandled rejection: %v", p.ref(), err)
+		if e.isStrict() {
+			panic(fmt.Sprintf("eventloop: %s: unhandled rejection: %v", p.ref(), err))
+		}
+	}
+}
+
+// SetStrict makes a rejection that nobody consumes crash the program instead
+// of only being logged. Strict mode is meant for tests and development.
+func (e *EventLoop) SetStrict(strict bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.strict = strict
+}
+
+func (e *EventLoop) isStrict() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.strict
+}
+
+// SetFatalPanicFilter lets panics that point at a bug crash the program
+// instead of rejecting: a panic in an Async worker or a Then callback for
+// which filter returns true is raised again, while any other panic still
+// becomes a rejection. A filter such as
+//
+//	func(r interface{}) bool { _, ok := r.(runtime.Error); return ok }
+//
+// keeps nil dereferences and index errors fatal. A nil filter, the default,
+// recovers every panic.
+func (e *EventLoop) SetFatalPanicFilter(filter func(recovered interface{}) bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.fatalPanic = filter
+}
+
+func (e *EventLoop) isFatalPanic(r interface{}) bool {
+	e.mu.RLock()
+	filter := e.fatalPanic
+	e.mu.RUnlock()
+	return filter != nil && filter(r)
+}
+
+// SetResultCopier gives every consumer of a promise its own copy of the
+// value, made by copier, so consumers of a mutable value such as a slice or
+// map cannot race on it: each Await, Then, OnResolveSync, Fork branch and
+// combinator input receives copier(value) instead of the shared value. A nil
+// copier, the default, shares the value.
+func (e *EventLoop) SetResultCopier(copier func(value interface{}) interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.copier = copier
+}
+
+func (e *EventLoop) copyResult(value interface{}) interface{} {
+	e.mu.RLock()
+	copier := e.copier
+	e.mu.RUnlock()
+	if copier == nil {
+		return value
+	}
+	return copier(value)
+}
+
+// ownedResult is p's value for one consumer, p being settled; a rejected p
+// has none.
+func (e *EventLoop) ownedResult(p *Promise) interface{} {
+	if p.reason != nil {
+		return nil
+	}
+	return e.copyResult(p.value)
+}
+
+// SetResultValidator runs v on every value a promise resolves with; if v
+// returns an error, or panics, the promise rejects with that instead. A nil v,
+// the default, turns validation off.
+func (e *EventLoop) SetResultValidator(v func(value interface{}) error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.validator = v
+}
+
+func (e *EventLoop) validate(value interface{}) error {
+	e.mu.RLock()
+	v := e.validator
+	e.mu.RUnlock()
+	if v == nil {
+		return nil
+	}
+	if s, ok := value.(stopValue); ok {
+		value = s.value
+	}
+	_, err := call(func() (interface{}, error) {
+		return nil, v(value)
+	})
+	return err
+}
+
+// Main runs fn and then waits until every promise with a handler is done,
+// i.e. every Await, Then and Catch attached to it has returned. Awaiting a
+// promise directly is no different: Main waits for it like for a Then, and
+// a handler attached after an Await has returned is waited for as well.
 func (e *EventLoop) Main(fn func()) {
 	fn()
 	//await all promises
 	e.awaitAll()
 }
 
+func (e *EventLoop) queue() []*Promise {
+	e.queueMu.Lock()
+	defer e.queueMu.Unlock()
+	return e.promiseQueue
+}
+
 func (e *EventLoop) awaitAll() {
-	n := len(e.promiseQueue)
+	queue := e.queue()
+	n := len(queue)
 	for i := n - 1; i >= 0; i-- {
-		p := e.promiseQueue[i]
-		if p.handler {
-			<-p.done
+		p := queue[i]
+		if p.hasHandler() {
+			e.waitDone(p)
 		}
-		if currentN := int(atomic.LoadUint64(&e.size)); i == 0 && currentN > n {
+		if i == 0 && len(e.queue()) > n {
 			// process fresh promise
 			e.awaitAll()
 		}
@@ -100,131 +468,420 @@ func (e *EventLoop) awaitAll() {
 
 //Promise
 
+// A promise settles exactly once, when its worker returns: either with a value
+// or with an error, never both. The outcome is kept on the promise, so every
+// Await, Then and Catch sees the same outcome no matter when it is attached,
+// and only Then or only Catch handlers react to it.
 type Promise struct {
 	id      uint64
-	handler bool
-	rev     <-chan interface{}
-	errChan chan error
-	err     chan struct{}
-	done    chan struct{}
+	name    string
+	tag     string
+	created time.Time
+	state   uint32
+	loop    *EventLoop
+	ctx     context.Context
+	parent  *Promise // the promise a derived stage was chained on
+
+	mu       sync.Mutex
+	scope    string
+	stages   int
+	thens    []*thenStage
+	handler  bool
+	handlers int
+	done     chan struct{}
+
+	settled    chan struct{}
+	settleOnce sync.Once
+	value      interface{}
+	reason     error
+	stopped    bool
+
+	lazy     func()
+	lazyOnce sync.Once
+	deadline *time.Timer
+	// releases a value that arrives after the promise has settled
+	discard func(value interface{})
+	// rejects a pending promise and tears its work down (AsyncCancelable)
+	cancel func()
+	// OnResolveSync and OnRejectSync callbacks, run by settle; nil once run
+	syncListeners []func(value interface{}, err error)
+	notified      bool
+	// what the worker is blocked on in AwaitContext, for cycle detection
+	awaiting *Promise
+
+	// fired once something is attached that observes the value or the error
+	valueHandled *event
+	errHandled   *event
+}
+
+// thenStage is a Then callback; a Catch attached later waits on it to learn
+// whether it panicked.
+type thenStage struct {
+	done chan struct{}
+	err  error
 }
 
-func (e *EventLoop) newPromise(rev <-chan interface{}, errChan chan error) *Promise {
-	currentP := &Promise{id: atomic.AddUint64(&e.size, 1), rev: rev, errChan: errChan, done: make(chan struct{}), err: make(chan struct{})}
+type event struct {
+	once sync.Once
+	ch   chan struct{}
+}
+
+func newEvent() *event {
+	return &event{ch: make(chan struct{})}
+}
+
+func (ev *event) fire() {
+	ev.once.Do(func() {
+		close(ev.ch)
+	})
+}
+
+func (e *EventLoop) newPromise(name string) *Promise {
+	return e.add(&Promise{name: name})
+}
+
+// add sets up currentP and queues it; fields set by the caller beforehand are
+// kept, so they can be read without locking.
+func (e *EventLoop) add(currentP *Promise) *Promise {
+	e.queueMu.Lock()
+	currentP.id = atomic.AddUint64(&e.lastID, 1)
+	currentP.created = time.Now()
+	currentP.loop = e
+	currentP.done = make(chan struct{})
+	currentP.settled = make(chan struct{})
+	currentP.valueHandled = newEvent()
+	currentP.errHandled = newEvent()
 	e.promiseQueue = append(e.promiseQueue, currentP)
+	e.queueMu.Unlock()
+	e.watchSlow(currentP)
 	return currentP
 }
 
-func (p *Promise) Done() {
-	close(p.done)
+func (p *Promise) ID() uint64 {
+	return p.id
 }
 
-func (p *Promise) RegisterHandler() {
-	p.handler = true
+func (p *Promise) Name() string {
+	return p.name
 }
 
-func (p *Promise) Then(fn func(interface{})) *Promise {
-	p.RegisterHandler()
-	go func() {
-		select {
-		case <-p.err:
-		case val := <-p.rev:
-			defer func() {
-				if r := recover(); r != nil {
-					switch x := r.(type) {
-					case error:
-						p.errChan <- x
-					default:
-						p.errChan <- fmt.Errorf("%v", x)
-					}
-				} else {
-					close(p.err)
-					p.Done()
-				}
-			}()
-			fn(val)
+func (p *Promise) Tag() string {
+	return p.tag
+}
+
+// State reports whether the promise's work has finished, and how.
+func (p *Promise) State() State {
+	return State(atomic.LoadUint32(&p.state))
+}
+
+// settle records the outcome of the promise's work. Only the first call has
+// any effect and reports true; a non-nil err wins over value.
+func (p *Promise) settle(value interface{}, err error) (first bool) {
+	p.settleOnce.Do(func() {
+		first = true
+		p.mu.Lock()
+		if p.deadline != nil {
+			p.deadline.Stop()
 		}
-	}()
-	return p
+		p.mu.Unlock()
+		if err != nil {
+			p.reason = err
+			atomic.StoreUint32(&p.state, uint32(Rejected))
+		} else {
+			if s, ok := value.(stopValue); ok {
+				value = s.value
+				p.stopped = true
+			}
+			p.value = value
+			atomic.StoreUint32(&p.state, uint32(Fulfilled))
+		}
+		close(p.settled)
+		atomic.AddUint32(&p.loop.settles, 1)
+		p.mu.Lock()
+		listeners := p.syncListeners
+		p.syncListeners, p.notified = nil, true
+		p.mu.Unlock()
+		for _, fn := range listeners {
+			p.notify(fn)
+		}
+	})
+	return first
 }
 
-func (p *Promise) Catch(fn func(err error)) {
-	p.RegisterHandler()
-	go func() {
-		select {
-		case <-p.err:
-		case err := <-p.errChan:
-			close(p.err)
+// OnResolveSync runs fn with the value once p resolves, inline on the
+// goroutine that settles p, or straight away if it already has. Unlike Then
+// it starts no goroutine, so fn must be quick and must not block on other
+// promises. A panic in fn is logged, or raised again under
+// SetFatalPanicFilter, and reaches no Catch.
+func (p *Promise) OnResolveSync(fn func(value interface{})) {
+	p.valueHandled.fire()
+	p.onSettleSync(func(value interface{}, err error) {
+		if err == nil {
+			fn(value)
+		}
+	})
+}
+
+// OnRejectSync is OnResolveSync for the rejection reason; it consumes the
+// rejection like Catch.
+func (p *Promise) OnRejectSync(fn func(err error)) {
+	p.errHandled.fire()
+	p.onSettleSync(func(_ interface{}, err error) {
+		if err != nil {
 			fn(err)
-			p.Done()
+		}
+	})
+}
+
+// onSettleSync registers fn as a handler, so Main waits for it, and runs it
+// once p settles without a goroutine of its own.
+func (p *Promise) onSettleSync(fn func(value interface{}, err error)) {
+	p.mu.Lock()
+	p.addHandlerLocked()
+	if !p.notified {
+		p.syncListeners = append(p.syncListeners, fn)
+		p.mu.Unlock()
+		p.trigger()
+		return
+	}
+	p.mu.Unlock()
+	p.notify(fn)
+}
+
+func (p *Promise) notify(fn func(value interface{}, err error)) {
+	defer p.handlerDone()
+	defer func() {
+		if r := recover(); r != nil {
+			if p.loop.isFatalPanic(r) {
+				panic(r)
+			}
+			p.loop.errorf("eventloop: %s: recovered panic in sync listener: %v", p.ref(), r)
 		}
 	}()
+	fn(p.loop.ownedResult(p), p.reason)
 }
 
-type Future struct {
-	completeChan  chan interface{}
-	onComFunc     interface{}
-	completeEvent []interface{}
-	signalCount   int // could be useful
+// trigger starts the work of a Lazy promise; it does nothing for the others.
+func (p *Promise) trigger() {
+	if p.lazy != nil {
+		p.lazyOnce.Do(p.lazy)
+	}
 }
 
-func (e *EventLoop) NewFuture() *Future {
-	return &Future{completeChan: make(chan interface{})}
+// Settled returns a channel that is closed once the promise's work has
+// finished, either way. Use TryResult to read the outcome afterwards.
+func (p *Promise) Settled() <-chan struct{} {
+	return p.settled
 }
 
-func (f *Future) GetCompleteEventFromFuture(signalId int) interface{} {
-	if signalId < f.signalCount {
-		return f.completeEvent[signalId]
+// TryResult returns the outcome without blocking; ok is false while the
+// promise is still pending.
+func (p *Promise) TryResult() (value interface{}, ok bool, err error) {
+	select {
+	case <-p.settled:
+		return p.loop.ownedResult(p), true, p.reason
+	default:
+		return nil, false, nil
 	}
-	return nil
 }
 
-func (f *Future) GetCompleteEventsFromFuture() []interface{} {
-	return f.completeEvent
+// Get is p's loop Await(p): it blocks until p settles and returns the stored
+// outcome, so it can be called any number of times.
+func (p *Promise) Get() (interface{}, error) {
+	return p.loop.Await(p)
 }
 
-func (f *Future) set(value interface{}, future string) {
-	switch future {
-	case "complete":
-		f.completeChan <- value
+// Done marks the promise as handled. It is safe to call more than once, e.g.
+// when both Await and Catch finish the same promise.
+func (p *Promise) Done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.isDoneLocked() {
+		close(p.done)
+	}
+}
+
+func (p *Promise) RegisterHandler() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handler = true
+}
+
+func (p *Promise) hasHandler() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.handler
+}
+
+// addHandler counts an attached handler; the promise is Done once every
+// handler has called handlerDone.
+func (p *Promise) addHandler() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.addHandlerLocked()
+}
+
+// addHandlerLocked is addHandler with p.mu held. A handler attached after p
+// was Done, e.g. a Then after an Await returned, makes p pending for Main
+// again, so Main waits for it instead of skipping it.
+func (p *Promise) addHandlerLocked() {
+	p.handler = true
+	p.handlers++
+	if p.handlers == 1 && p.isDoneLocked() {
+		p.done = make(chan struct{})
+	}
+}
+
+func (p *Promise) isDoneLocked() bool {
+	select {
+	case <-p.done:
+		return true
 	default:
+		return false
 	}
 }
 
-func (f *Future) RegisterComplete(futureFunc interface{}) {
-	f.onComFunc = futureFunc
+// doneChan returns the channel closed once p is Done; it is replaced when a
+// handler is attached to a promise that was already Done.
+func (p *Promise) doneChan() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.done
+}
+
+func (p *Promise) handlerDone() {
+	p.mu.Lock()
+	p.handlers--
+	idle := p.handlers == 0
+	p.mu.Unlock()
+	if idle {
+		p.Done()
+	}
 }
 
-func (f *Future) signal() {
-	// maybe this should be a blocking call?
+// Then runs fn with the value once the promise resolves; it is skipped on
+// rejection. Every Then on a promise receives the same value, and a panic in
+// fn is delivered as a PanicError to the Catch handlers attached after it.
+func (p *Promise) Then(fn func(interface{})) *Promise {
+	// a nil callback leaves the settlement for the next handler in the chain
+	if fn == nil {
+		return p
+	}
+	st := &thenStage{done: make(chan struct{})}
+	p.mu.Lock()
+	p.addHandlerLocked()
+	p.stages++
+	stage := p.stages
+	p.thens = append(p.thens, st)
+	p.mu.Unlock()
+	p.valueHandled.fire()
+	p.trigger()
+
 	go func() {
-	Loop:
-		for {
-			select {
-			case e := <-f.completeChan:
-				f.completeEvent = append(f.completeEvent, e)
-				f.signalCount++
-				break Loop
-			default:
-				break Loop
-			}
+		defer p.handlerDone()
+		defer close(st.done)
+		<-p.settled
+		if p.reason != nil {
+			return
 		}
+		p.loop.dispatch(func() {
+			end := p.loop.startSpan(p, stage)
+			defer func() {
+				end(st.err)
+			}()
+			defer func() {
+				if r := recover(); r != nil {
+					if p.loop.isFatalPanic(r) {
+						panic(r)
+					}
+					p.loop.errorf("eventloop: %s: recovered panic in Then: %v", p.ref(), r)
+					st.err = &PanicError{PromiseID: p.id, Name: p.name, Stage: stage, Value: r}
+				}
+			}()
+			fn(p.loop.ownedResult(p))
+		})
 	}()
+	return p
 }
 
-func (f *Future) SignalComplete(value interface{}) {
-	if f.onComFunc != nil {
-		go func() {
-			f.onComFunc.(func(interface{}))(value)
-			// should handle error here -- only if user registered a function for a future error event
-			f.set(value, "complete")
-		}()
-		f.signal()
-	} else {
-		panic("no function registered for future event [SignalComplete]")
+// Catch runs fn with the rejection reason, or with the first panic raised by
+// a Then attached before it. It does nothing if neither happens.
+func (p *Promise) Catch(fn func(err error)) {
+	if fn == nil {
+		return
+	}
+	p.mu.Lock()
+	p.addHandlerLocked()
+	p.stages++
+	stage := p.stages
+	thens := append([]*thenStage(nil), p.thens...)
+	p.mu.Unlock()
+	p.errHandled.fire()
+	p.trigger()
+
+	go func() {
+		defer p.handlerDone()
+		<-p.settled
+		err := p.reason
+		for i := 0; err == nil && i < len(thens); i++ {
+			<-thens[i].done
+			err = thens[i].err
+		}
+		if err == nil {
+			return
+		}
+		if err = p.loop.handleError(err); err == nil {
+			return
+		}
+		p.loop.dispatch(func() {
+			// nothing downstream can receive the error, so re-panic with
+			// the promise identity attached
+			defer func() {
+				if r := recover(); r != nil {
+					panic(&PanicError{PromiseID: p.id, Name: p.name, Stage: stage, Value: r})
+				}
+			}()
+			fn(err)
+		})
+	}()
+}
+
+// Fork awaits p once and returns two promises that both settle with its
+// outcome, so two independent consumers can each attach their own Then/Catch.
+func (p *Promise) Fork() (*Promise, *Promise) {
+	var value interface{}
+	var err error
+	settled := make(chan struct{})
+	go func() {
+		value, err = p.loop.Await(p)
+		close(settled)
+	}()
+	fork := func() (interface{}, error) {
+		<-settled
+		if err != nil {
+			return nil, err
+		}
+		return p.loop.copyResult(value), nil
 	}
+	return p.loop.coordinate(fork), p.loop.coordinate(fork)
 }
 
-func (f *Future) SigalCount() int {
-	return f.signalCount
+// ChainDepth reports how many stages follow from p: the Then and Catch
+// callbacks attached to it, and every promise derived from it (ThenMap,
+// Branch, CatchIs and the like), directly or through other derived stages.
+// Derived stages are found through their parent links, so it takes time
+// proportional to the loop's queue.
+func (p *Promise) ChainDepth() int {
+	p.mu.Lock()
+	n := p.stages
+	p.mu.Unlock()
+	for _, q := range p.loop.queue() {
+		for r := q.parent; r != nil; r = r.parent {
+			if r == p {
+				n++
+				break
+			}
+		}
+	}
+	return n
 }
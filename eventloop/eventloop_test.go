@@ -0,0 +1,267 @@
+package eventloop_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alob-mtc/go-promise/eventloop"
+	"github.com/alob-mtc/go-promise/eventloop/promisetest"
+)
+
+func TestDoneIsIdempotent(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	p := e.Async(func() (interface{}, error) {
+		return nil, errBoom
+	})
+	caught := make(chan error, 1)
+	p.Catch(func(err error) {
+		caught <- err
+	})
+	if _, err := e.Await(p); err != errBoom {
+		t.Fatalf("Await got %v, want %v", err, errBoom)
+	}
+	if err := <-caught; err != errBoom {
+		t.Fatalf("Catch got %v, want %v", err, errBoom)
+	}
+	p.Done()
+	p.Done()
+	e.Main(func() {})
+}
+
+func TestAwaitTwiceReturnsSameResult(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	p := e.Async(func() (interface{}, error) {
+		return &struct{}{}, nil
+	})
+	first, err := e.Await(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := e.Await(p)
+	if err != nil || second != first {
+		t.Fatalf("second Await = %p, %v, want %p", second, err, first)
+	}
+	then := make(chan interface{}, 1)
+	p.Then(func(v interface{}) {
+		then <- v
+	})
+	if v := <-then; v != first {
+		t.Fatalf("Then got %p, want %p", v, first)
+	}
+	if v, ok, err := p.TryResult(); !ok || err != nil || v != first {
+		t.Fatalf("TryResult = %p, %v, %v, want %p", v, ok, err, first)
+	}
+}
+
+func TestHandlersAttachedAfterSettling(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	resolved := e.Async(func() (interface{}, error) {
+		return 1, nil
+	})
+	rejected := e.Async(func() (interface{}, error) {
+		return nil, errBoom
+	})
+	promisetest.AssertResolves(t, resolved, 1)
+	promisetest.AssertRejects(t, rejected, errBoom)
+
+	thens := make(chan interface{}, 2)
+	caught := make(chan error, 1)
+	resolved.Then(func(v interface{}) {
+		thens <- v
+	})
+	resolved.Then(func(v interface{}) {
+		thens <- v
+	})
+	rejected.Catch(func(err error) {
+		caught <- err
+	})
+	for i := 0; i < 2; i++ {
+		select {
+		case v := <-thens:
+			if v != 1 {
+				t.Fatalf("Then got %v, want 1", v)
+			}
+		case <-time.After(promisetest.Timeout):
+			t.Fatalf("%d of 2 Then callbacks ran on a settled promise", i)
+		}
+	}
+	select {
+	case err := <-caught:
+		if err != errBoom {
+			t.Fatalf("Catch got %v, want %v", err, errBoom)
+		}
+	case <-time.After(promisetest.Timeout):
+		t.Fatal("Catch did not run on a settled promise")
+	}
+}
+
+func TestAwaitInterruptible(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	release := make(chan struct{})
+	p := e.Async(func() (interface{}, error) {
+		<-release
+		return 1, nil
+	})
+	interrupt := make(chan struct{}, 1)
+	interrupt <- struct{}{}
+	if v, completed, err := e.AwaitInterruptible(p, interrupt); completed || v != nil || err != nil {
+		t.Fatalf("AwaitInterruptible = %v, %v, %v, want an interrupted wait", v, completed, err)
+	}
+	close(release)
+	promisetest.AssertResolves(t, p, 1)
+	if v, completed, err := e.AwaitInterruptible(p, make(chan struct{})); !completed || v != 1 || err != nil {
+		t.Fatalf("AwaitInterruptible = %v, %v, %v, want 1, true, nil", v, completed, err)
+	}
+}
+
+func TestIDsUniqueAndMonotonic(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	const n = 100
+	ids := make(chan uint64, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids <- e.Async(func() (interface{}, error) {
+				return nil, nil
+			}).ID()
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	seen := make(map[uint64]bool)
+	var last uint64
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("ID %d assigned twice", id)
+		}
+		seen[id] = true
+		if id > last {
+			last = id
+		}
+	}
+	e.Main(func() {})
+	for i := 0; i < 3; i++ {
+		id := e.Async(func() (interface{}, error) {
+			return nil, nil
+		}).ID()
+		if id <= last {
+			t.Fatalf("ID %d after %d", id, last)
+		}
+		last = id
+	}
+}
+
+func TestMainDrainsPromisesCreatedWhileDraining(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	var ran int32
+	e.Main(func() {
+		e.Async(func() (interface{}, error) {
+			return nil, nil
+		}).Then(func(interface{}) {
+			e.Async(func() (interface{}, error) {
+				time.Sleep(10 * time.Millisecond)
+				return nil, nil
+			}).Then(func(interface{}) {
+				atomic.StoreInt32(&ran, 1)
+			})
+		})
+	})
+	if atomic.LoadInt32(&ran) != 1 {
+		t.Fatal("Main returned before a promise created while draining was done")
+	}
+}
+
+func TestMainWithDirectAwait(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	var thens int32
+	e.Main(func() {
+		promises := make([]*eventloop.Promise, 3)
+		for i := range promises {
+			i := i
+			promises[i] = e.Async(func() (interface{}, error) {
+				return i, nil
+			})
+		}
+		if v, err := e.Await(promises[0]); err != nil || v != 0 {
+			t.Errorf("Await = %v, %v, want 0", v, err)
+		}
+		for _, p := range promises {
+			p.Then(func(interface{}) {
+				time.Sleep(10 * time.Millisecond)
+				atomic.AddInt32(&thens, 1)
+			})
+		}
+		if v, err := e.Await(promises[1]); err != nil || v != 1 {
+			t.Errorf("Await = %v, %v, want 1", v, err)
+		}
+	})
+	if n := atomic.LoadInt32(&thens); n != 3 {
+		t.Fatalf("Main returned after %d of 3 Then callbacks", n)
+	}
+}
+
+func BenchmarkQueueCapacity(b *testing.B) {
+	const n = 1000
+	for _, bc := range []struct {
+		name     string
+		capacity int
+	}{{"grow", 0}, {"preallocated", n}} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				e := eventloop.NewEventLoopWithCapacity(bc.capacity)
+				promises := make([]*eventloop.Promise, n)
+				for j := range promises {
+					promises[j] = e.Async(func() (interface{}, error) {
+						return nil, nil
+					})
+				}
+				for _, p := range promises {
+					e.Await(p)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkChain(b *testing.B) {
+	const stages = 1000
+	b.Run("ThenMap", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			e := eventloop.NewEventLoopWithCapacity(stages + 1)
+			p := e.Async(func() (interface{}, error) {
+				return 0, nil
+			})
+			for j := 0; j < stages; j++ {
+				p = p.ThenMap(func(v interface{}) (interface{}, error) {
+					return v.(int) + 1, nil
+				})
+			}
+			if v, err := e.Await(p); err != nil || v != stages {
+				b.Fatalf("chain = %v, %v, want %d", v, err, stages)
+			}
+		}
+	})
+	b.Run("Then", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			e := eventloop.NewEventLoopWithCapacity(1)
+			p := e.Async(func() (interface{}, error) {
+				return 0, nil
+			})
+			var wg sync.WaitGroup
+			wg.Add(stages)
+			for j := 0; j < stages; j++ {
+				p = p.Then(func(interface{}) {
+					wg.Done()
+				})
+			}
+			wg.Wait()
+		}
+	})
+}
@@ -0,0 +1,414 @@
+package eventloop
+
+import (
+	"sync"
+	"time"
+)
+
+type Future struct {
+	completeChan  chan interface{}
+	errorChan     chan error
+	onComFunc     interface{}
+	completeEvent []interface{}
+	errorEvent    []error
+	signalCount   int // could be useful
+
+	mu           sync.Mutex
+	listeners    []*listener
+	onErrFunc    func(error)
+	onTimeout    func()
+	timeoutEvent []time.Time
+	resolved     int // completions signalled so far, counted at once
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	teardown  func() // set by FromEmitter
+
+	recorded *sync.Cond // on mu; broadcast when an event is recorded or f closes
+	next     int        // completion events returned by Next so far
+}
+
+type listener struct {
+	fn func(value interface{}, err error)
+}
+
+func (e *EventLoop) NewFuture() *Future {
+	return newFuture()
+}
+
+func newFuture() *Future {
+	f := &Future{completeChan: make(chan interface{}), errorChan: make(chan error), closed: make(chan struct{})}
+	f.recorded = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *Future) GetCompleteEventFromFuture(signalId int) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if signalId < f.signalCount {
+		return f.completeEvent[signalId]
+	}
+	return nil
+}
+
+// GetCompleteEventsFromFuture returns a copy of the completion events so far;
+// later signals do not change it.
+func (f *Future) GetCompleteEventsFromFuture() []interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]interface{}(nil), f.completeEvent...)
+}
+
+// GetErrorEventsFromFuture returns a copy of the error events so far.
+func (f *Future) GetErrorEventsFromFuture() []error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]error(nil), f.errorEvent...)
+}
+
+func (f *Future) RegisterComplete(futureFunc interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.onComFunc = futureFunc
+}
+
+// RegisterError sets the function RejectFuture calls with each error.
+func (f *Future) RegisterError(fn func(error)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.onErrFunc = fn
+}
+
+// RegisterTimeout sets the function SignalTimeout calls.
+func (f *Future) RegisterTimeout(fn func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.onTimeout = fn
+}
+
+// GetTimeoutEventsFromFuture returns when each timeout event was signalled.
+func (f *Future) GetTimeoutEventsFromFuture() []time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]time.Time(nil), f.timeoutEvent...)
+}
+
+// listen calls fn synchronously for every later completion or error event
+// until the returned function removes it.
+func (f *Future) listen(fn func(value interface{}, err error)) func() {
+	l := &listener{fn: fn}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.listeners = append(f.listeners, l)
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		// build a new slice; emit may still be ranging over the old one
+		kept := make([]*listener, 0, len(f.listeners))
+		for _, other := range f.listeners {
+			if other != l {
+				kept = append(kept, other)
+			}
+		}
+		f.listeners = kept
+	}
+}
+
+func (f *Future) snapshot() (interface{}, []*listener) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.onComFunc, f.listeners
+}
+
+// signal records the next outcome sent on either channel. Every send is
+// paired with exactly one signal, so the receive blocks until it arrives.
+func (f *Future) signal() {
+	go func() {
+		select {
+		case e := <-f.completeChan:
+			f.mu.Lock()
+			f.completeEvent = append(f.completeEvent, e)
+			f.signalCount++
+			f.recorded.Broadcast()
+			f.mu.Unlock()
+		case err := <-f.errorChan:
+			f.mu.Lock()
+			f.errorEvent = append(f.errorEvent, err)
+			f.mu.Unlock()
+		}
+	}()
+}
+
+// ResolveFuture runs the registered complete function with value and records
+// it as a completion event. It returns ErrNoHandler if neither a function nor
+// a derived future (Map) is registered.
+func (f *Future) ResolveFuture(value interface{}) error {
+	handler, listeners := f.snapshot()
+	if handler == nil && len(listeners) == 0 {
+		return ErrNoHandler
+	}
+	f.emit(handler, listeners, value)
+	return nil
+}
+
+func (f *Future) emit(handler interface{}, listeners []*listener, value interface{}) {
+	f.mu.Lock()
+	f.resolved++
+	f.mu.Unlock()
+	go func() {
+		if handler != nil {
+			handler.(func(interface{}))(value)
+		}
+		f.completeChan <- value
+	}()
+	f.signal()
+	for _, l := range listeners {
+		l.fn(value, nil)
+	}
+}
+
+// RejectFuture runs the registered error function, if any, with err and
+// records it as an error event.
+func (f *Future) RejectFuture(err error) {
+	_, listeners := f.snapshot()
+	f.mu.Lock()
+	handler := f.onErrFunc
+	f.mu.Unlock()
+	go func() {
+		if handler != nil {
+			handler(err)
+		}
+		f.errorChan <- err
+	}()
+	f.signal()
+	for _, l := range listeners {
+		l.fn(nil, err)
+	}
+}
+
+// SignalTimeout records a timeout event and runs the registered timeout
+// function, if any.
+func (f *Future) SignalTimeout() {
+	f.mu.Lock()
+	f.timeoutEvent = append(f.timeoutEvent, time.Now())
+	handler := f.onTimeout
+	f.mu.Unlock()
+	if handler != nil {
+		go handler()
+	}
+}
+
+// WithTimeout signals a timeout event if no completion is signalled within d
+// of the call. It returns f.
+func (f *Future) WithTimeout(d time.Duration) *Future {
+	f.mu.Lock()
+	start := f.resolved
+	f.mu.Unlock()
+	time.AfterFunc(d, func() {
+		f.mu.Lock()
+		timedOut := f.resolved == start
+		f.mu.Unlock()
+		if timedOut {
+			f.SignalTimeout()
+		}
+	})
+	return f
+}
+
+// Map returns a future whose completion events are transform applied to f's
+// completion events; error events pass through unchanged, and a panic in
+// transform becomes an error event. The mapped future records its events
+// whether or not a complete function is registered on it. Closing the mapped
+// future removes its listener from f.
+func (f *Future) Map(transform func(interface{}) interface{}) *Future {
+	mapped := newFuture()
+	mapped.teardown = f.listen(func(value interface{}, err error) {
+		if err != nil {
+			mapped.RejectFuture(err)
+			return
+		}
+		v, err := call(func() (interface{}, error) {
+			return transform(value), nil
+		})
+		if err != nil {
+			mapped.RejectFuture(err)
+			return
+		}
+		handler, listeners := mapped.snapshot()
+		mapped.emit(handler, listeners, v)
+	})
+	return mapped
+}
+
+// Close tears down the source feeding f, such as FromEmitter's subscription,
+// once; later calls do nothing. Events the source emits afterwards are
+// dropped, and Next reports the end. Stages still waiting for an event of f,
+// in ThenFirst, Drive or AsPromise, reject with ErrStreamClosed. Signalling f
+// directly is not affected.
+func (f *Future) Close() {
+	f.closeOnce.Do(func() {
+		close(f.closed)
+		f.mu.Lock()
+		teardown := f.teardown
+		f.recorded.Broadcast()
+		f.mu.Unlock()
+		if teardown != nil {
+			teardown()
+		}
+	})
+}
+
+// Next blocks until there is a completion event that Next has not returned
+// yet and returns it with true, so completions can be consumed in a loop.
+// Events are returned once each, in the order they were recorded, and none is
+// missed between calls; concurrent callers each get different events. Once f
+// is closed and every recorded event has been returned, Next returns nil and
+// false.
+func (f *Future) Next() (interface{}, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for f.next >= len(f.completeEvent) {
+		select {
+		case <-f.closed:
+			return nil, false
+		default:
+		}
+		f.recorded.Wait()
+	}
+	value := f.completeEvent[f.next]
+	f.next++
+	return value, true
+}
+
+// SignalComplete panics when no complete function is registered; use
+// TrySignalComplete to get ErrNoHandler instead.
+func (f *Future) SignalComplete(value interface{}) {
+	if err := f.ResolveFuture(value); err != nil {
+		panic("no function registered for future event [SignalComplete]")
+	}
+}
+
+func (f *Future) TrySignalComplete(value interface{}) error {
+	return f.ResolveFuture(value)
+}
+
+func (f *Future) SigalCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.signalCount
+}
+
+// WaitForDistinct resolves once n distinct keys have been seen among the
+// completion events f signals from the call on, with the first event for each
+// key in the order they arrived. Events with an already seen key do not count,
+// and error events are skipped. A panic in key rejects the promise, as does
+// f closing first, with ErrStreamClosed. The listener is removed from f once
+// the promise settles.
+func (e *EventLoop) WaitForDistinct(f *Future, n int, key func(interface{}) string) *Promise {
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	var distinct []interface{}
+	var failed error
+	done := make(chan struct{})
+	if n <= 0 {
+		close(done)
+	}
+	stop := f.listen(func(value interface{}, err error) {
+		if err != nil {
+			return
+		}
+		k, err := call(func() (interface{}, error) {
+			return key(value), nil
+		})
+		mu.Lock()
+		defer mu.Unlock()
+		if len(distinct) >= n || failed != nil {
+			return
+		}
+		if err != nil {
+			failed = err
+			close(done)
+			return
+		}
+		if seen[k.(string)] {
+			return
+		}
+		seen[k.(string)] = true
+		distinct = append(distinct, value)
+		if len(distinct) == n {
+			close(done)
+		}
+	})
+	return e.coordinate(func() (interface{}, error) {
+		defer stop()
+		select {
+		case <-done:
+		case <-f.closed:
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if len(distinct) < n && failed == nil {
+			failed = ErrStreamClosed
+		}
+		if failed != nil {
+			return nil, failed
+		}
+		return distinct, nil
+	})
+}
+
+// FromEmitter bridges a callback-based event source, such as a pub/sub client
+// or a websocket, into a future. subscribe is called straight away with an
+// emit function that signals each event as a completion event, recorded
+// whether or not a complete function is registered, like Map. The unsubscribe
+// function subscribe returns is called exactly once, on f.Close or, on a loop
+// made by NewEventLoopWithContext, once the loop's context is done.
+func (e *EventLoop) FromEmitter(subscribe func(emit func(interface{})) (unsubscribe func())) *Future {
+	f := newFuture()
+	unsubscribe := subscribe(func(value interface{}) {
+		select {
+		case <-f.closed:
+			return
+		default:
+		}
+		handler, listeners := f.snapshot()
+		f.emit(handler, listeners, value)
+	})
+	f.mu.Lock()
+	f.teardown = unsubscribe
+	f.mu.Unlock()
+	if e.ctx != nil {
+		go func() {
+			select {
+			case <-e.ctx.Done():
+				f.Close()
+			case <-f.closed:
+			}
+		}()
+	}
+	return f
+}
+
+// AsPromise returns a promise on e that settles with the first event f
+// signals from the call on: it resolves with a completion event or rejects
+// with an error event, so a single-shot future can be used with combinators.
+// If f is closed first, the promise rejects with ErrStreamClosed. The
+// listener is removed from f once the promise settles.
+func (f *Future) AsPromise(e *EventLoop) *Promise {
+	first := make(chan SettledResult, 1)
+	stop := f.listen(func(value interface{}, err error) {
+		select {
+		case first <- settledResult(value, err):
+		default:
+		}
+	})
+	return e.coordinate(func() (interface{}, error) {
+		defer stop()
+		select {
+		case r := <-first:
+			return r.Value, r.Err
+		case <-f.closed:
+			return nil, ErrStreamClosed
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package eventloop_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/alob-mtc/go-promise/eventloop"
+)
+
+func TestCompleteEventsSnapshotIsStable(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	f := e.NewFuture()
+	f.RegisterComplete(func(interface{}) {})
+	const n = 100
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			f.SignalComplete(i)
+		}(i)
+	}
+	for i := 0; i < n; i++ {
+		snapshot := f.GetCompleteEventsFromFuture()
+		for j := range snapshot {
+			snapshot[j] = nil
+		}
+	}
+	wg.Wait()
+	for i := 0; i < n; i++ {
+		f.Next()
+	}
+	events := f.GetCompleteEventsFromFuture()
+	if len(events) != n {
+		t.Fatalf("got %d events, want %d", len(events), n)
+	}
+	for _, event := range events {
+		if event == nil {
+			t.Fatal("a snapshot write reached the future's events")
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package eventloop
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrInvalidDependency = errors.New("eventloop: dependency is not a node of this graph")
+
+// Graph runs functions in dependency order. A node can only depend on nodes
+// that already exist in the same graph, so the graph cannot contain a cycle;
+// any other dependency is rejected when the node is added.
+type Graph struct {
+	loop *EventLoop
+
+	mu    sync.Mutex
+	nodes []*Node
+	err   error
+}
+
+type Node struct {
+	graph      *Graph
+	index      int
+	fn         func(deps ...interface{}) (interface{}, error)
+	deps       []*Node
+	dependents int
+}
+
+func (e *EventLoop) NewGraph() *Graph {
+	return &Graph{loop: e}
+}
+
+// Node adds fn to the graph. fn runs once all deps have resolved and receives
+// their values in the order the deps were given.
+func (g *Graph) Node(fn func(deps ...interface{}) (interface{}, error), deps ...*Node) *Node {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n := &Node{graph: g, index: len(g.nodes), fn: fn, deps: deps}
+	for _, d := range deps {
+		if d == nil || d.graph != g {
+			if g.err == nil {
+				g.err = ErrInvalidDependency
+			}
+			continue
+		}
+		d.dependents++
+	}
+	g.nodes = append(g.nodes, n)
+	return n
+}
+
+type nodeState struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// Run executes the graph and resolves with the values of the terminal nodes
+// (those nothing depends on) in the order they were added. A failing node
+// rejects every node that depends on it, without running them, and the graph.
+func (g *Graph) Run() *Promise {
+	g.mu.Lock()
+	nodes := append([]*Node(nil), g.nodes...)
+	terminal := make([]bool, len(nodes))
+	for i, n := range nodes {
+		terminal[i] = n.dependents == 0
+	}
+	buildErr := g.err
+	g.mu.Unlock()
+
+	return g.loop.coordinate(func() (interface{}, error) {
+		if buildErr != nil {
+			return nil, buildErr
+		}
+
+		states := make([]*nodeState, len(nodes))
+		for i := range nodes {
+			states[i] = &nodeState{done: make(chan struct{})}
+		}
+		for i, n := range nodes {
+			go func(n *Node, s *nodeState) {
+				defer close(s.done)
+				args := make([]interface{}, len(n.deps))
+				for j, d := range n.deps {
+					dep := states[d.index]
+					<-dep.done
+					if dep.err != nil {
+						s.err = dep.err
+						return
+					}
+					args[j] = dep.value
+				}
+				s.value, s.err = g.loop.Await(g.loop.Async(func() (interface{}, error) {
+					return n.fn(args...)
+				}))
+			}(n, states[i])
+		}
+
+		var results []interface{}
+		for i, s := range states {
+			if !terminal[i] {
+				continue
+			}
+			<-s.done
+			if s.err != nil {
+				return nil, s.err
+			}
+			results = append(results, s.value)
+		}
+		return results, nil
+	})
+}
@@ -0,0 +1,79 @@
+package eventloop
+
+import (
+	"context"
+	"sync"
+)
+
+// PromiseGroup runs promises that share a context and fail together, like
+// errgroup.WithContext.
+type PromiseGroup struct {
+	loop   *EventLoop
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	promises []*Promise
+	err      error
+}
+
+// Group returns an empty group. On a loop made by NewEventLoopWithContext the
+// group's context is derived from the loop's.
+func (e *EventLoop) Group() *PromiseGroup {
+	parent := e.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	return &PromiseGroup{loop: e, ctx: ctx, cancel: cancel}
+}
+
+// Go starts fn as an AsyncContext worker with the group's context. The first
+// rejection in the group cancels that context; every other member that
+// finishes after that rejects with context.Canceled, whatever it returned.
+func (g *PromiseGroup) Go(fn func(ctx context.Context) (interface{}, error)) *Promise {
+	p := g.loop.AsyncContext(g.ctx, func(ctx context.Context) (interface{}, error) {
+		// a panic counts as the member's rejection
+		value, err := call(func() (interface{}, error) {
+			return fn(ctx)
+		})
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if err != nil && g.err == nil {
+			g.err = err
+			g.cancel()
+			return nil, err
+		}
+		if g.err != nil {
+			return nil, context.Canceled
+		}
+		return value, nil
+	})
+	g.mu.Lock()
+	g.promises = append(g.promises, p)
+	g.mu.Unlock()
+	return p
+}
+
+// Wait returns a promise that settles once every member started so far has
+// settled: it resolves with their values in the order Go was called, or rejects
+// with the group's first error. The group's context is cancelled either way.
+func (g *PromiseGroup) Wait() *Promise {
+	g.mu.Lock()
+	promises := append([]*Promise(nil), g.promises...)
+	g.mu.Unlock()
+	return g.loop.coordinate(func() (interface{}, error) {
+		values := make([]interface{}, len(promises))
+		for r := range g.loop.AsCompleted(promises) {
+			values[r.Index] = r.Value
+		}
+		g.mu.Lock()
+		err := g.err
+		g.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		g.cancel()
+		return values, nil
+	})
+}
@@ -0,0 +1,74 @@
+package eventloop
+
+import "sync"
+
+// IdempotencyStore keeps the results of work that completed, keyed by an
+// idempotency key. Implementations backed by a database make AsyncIdempotent
+// survive restarts.
+type IdempotencyStore interface {
+	Get(key string) (value interface{}, ok bool, err error)
+	Set(key string, value interface{}) error
+}
+
+// MemoryStore is an IdempotencyStore held in memory, the default.
+type MemoryStore struct {
+	mu     sync.Mutex
+	values map[string]interface{}
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{values: map[string]interface{}{}}
+}
+
+func (s *MemoryStore) Get(key string) (interface{}, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	return value, ok, nil
+}
+
+func (s *MemoryStore) Set(key string, value interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	return nil
+}
+
+// SetIdempotencyStore sets the store AsyncIdempotent uses; nil restores a
+// fresh in-memory store.
+func (e *EventLoop) SetIdempotencyStore(s IdempotencyStore) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.idempotency = s
+}
+
+func (e *EventLoop) idempotencyStore() IdempotencyStore {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.idempotency == nil {
+		e.idempotency = NewMemoryStore()
+	}
+	return e.idempotency
+}
+
+// AsyncIdempotent is Async that runs fn at most once successfully per key: if
+// the store already holds a result for key, the promise resolves with it and
+// fn does not run. Only successful results are stored, so a failed run is
+// retried by the next call. Calls racing on the same key may both run fn; use
+// CachedAsync to share an in-flight run. A store error rejects the promise.
+func (e *EventLoop) AsyncIdempotent(key string, fn func() (interface{}, error)) *Promise {
+	store := e.idempotencyStore()
+	return e.Async(func() (interface{}, error) {
+		value, ok, err := store.Get(key)
+		if err != nil || ok {
+			return value, err
+		}
+		if value, err = fn(); err != nil {
+			return nil, err
+		}
+		if err := store.Set(key, value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	})
+}
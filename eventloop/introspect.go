@@ -0,0 +1,159 @@
+package eventloop
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// PromiseInfo is a snapshot of a promise for diagnostics.
+type PromiseInfo struct {
+	ID    uint64
+	Name  string
+	Scope string
+	Age   time.Duration
+	State State
+}
+
+func (i PromiseInfo) String() string {
+	age := i.Age.Round(time.Millisecond)
+	s := fmt.Sprintf("promise %d", i.ID)
+	if i.Name != "" {
+		s += " (" + i.Name + ")"
+	}
+	if i.Scope != "" {
+		s += " in " + i.Scope
+	}
+	return fmt.Sprintf("%s %s for %s", s, i.State, age)
+}
+
+// PendingPromises lists the promises whose work has not finished yet, oldest
+// first.
+func (e *EventLoop) PendingPromises() []PromiseInfo {
+	now := time.Now()
+	var pending []PromiseInfo
+	for _, p := range e.queue() {
+		if state := p.State(); state == Pending {
+			pending = append(pending, PromiseInfo{ID: p.id, Name: p.name, Scope: p.Scope(), Age: now.Sub(p.created), State: state})
+		}
+	}
+	return pending
+}
+
+// SetStallTimeout turns on a best-effort deadlock check for Main: whenever the
+// drain waits d without any promise settling, the pending promises are
+// reported to the logger. The check runs again every d until progress is made.
+// d <= 0 turns it off, which is the default.
+func (e *EventLoop) SetStallTimeout(d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stall = d
+}
+
+func (e *EventLoop) stallTimeout() time.Duration {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.stall
+}
+
+// SetSlowThreshold calls onSlow, on its own goroutine, for every promise
+// created afterwards that is still pending d after it was created, with how
+// long it has been pending. It only reports: the promise is left to settle,
+// and onSlow runs at most once per promise. A lazy promise counts from its
+// creation, not from when it starts. d <= 0 turns the watchdog off, which is
+// the default.
+func (e *EventLoop) SetSlowThreshold(d time.Duration, onSlow func(p *Promise, elapsed time.Duration)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.slow, e.onSlow = d, onSlow
+}
+
+func (e *EventLoop) watchSlow(p *Promise) {
+	e.mu.RLock()
+	d, onSlow := e.slow, e.onSlow
+	e.mu.RUnlock()
+	if d <= 0 || onSlow == nil {
+		return
+	}
+	time.AfterFunc(d, func() {
+		if p.State() == Pending {
+			onSlow(p, time.Since(p.created))
+		}
+	})
+}
+
+// waitDone blocks until p is done, reporting a stall whenever a whole
+// stallTimeout passes without any promise on the loop settling. A handler
+// attached while it waits, or just after, keeps it waiting.
+func (e *EventLoop) waitDone(p *Promise) {
+	d := e.stallTimeout()
+	if d <= 0 {
+		done := p.doneChan()
+		for {
+			<-done
+			next := p.doneChan()
+			if next == done {
+				return
+			}
+			done = next
+		}
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	settles := atomic.LoadUint32(&e.settles)
+	done := p.doneChan()
+	for {
+		select {
+		case <-done:
+			next := p.doneChan()
+			if next == done {
+				return
+			}
+			done = next
+		case <-timer.C:
+			if n := atomic.LoadUint32(&e.settles); n != settles {
+				// another promise settled, so the loop is still moving
+				settles = n
+				timer.Reset(d)
+				continue
+			}
+			pending := e.PendingPromises()
+			names := make([]string, len(pending))
+			for i, info := range pending {
+				names[i] = info.String()
+			}
+			e.errorf("eventloop: no progress for %s waiting on promise %d, possible deadlock; pending: [%s]", d, p.id, strings.Join(names, ", "))
+			timer.Reset(d)
+		}
+	}
+}
+
+// ExportGraph renders the loop's promises as a Graphviz DOT digraph: one node
+// per promise, labelled with its ID, name and state, and an edge from each
+// promise to the stages derived from it (ThenMap, Branch and the like). It
+// only reads a snapshot of the queue.
+func (e *EventLoop) ExportGraph() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("digraph promises {\n")
+	queue := e.queue()
+	for _, p := range queue {
+		label := fmt.Sprintf("%d", p.id)
+		if p.name != "" {
+			label += " " + p.name
+		}
+		if p.tag != "" {
+			label += " [" + p.tag + "]"
+		}
+		label += "\n" + p.State().String()
+		fmt.Fprintf(&buf, "\tp%d [label=%q];\n", p.id, label)
+	}
+	for _, p := range queue {
+		if p.parent != nil {
+			fmt.Fprintf(&buf, "\tp%d -> p%d;\n", p.parent.id, p.id)
+		}
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes(), nil
+}
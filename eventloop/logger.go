@@ -0,0 +1,34 @@
+package eventloop
+
+// Logger receives diagnostics from the loop: recovered panics are logged
+// with Errorf, results nobody consumed within DeliveryTimeout with Debugf, and
+// rejections nobody handled within DeliveryTimeout with Errorf.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// SetLogger installs l on the loop; a nil logger turns logging off.
+func (e *EventLoop) SetLogger(l Logger) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.logger = l
+}
+
+func (e *EventLoop) getLogger() Logger {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.logger
+}
+
+func (e *EventLoop) debugf(format string, args ...interface{}) {
+	if l := e.getLogger(); l != nil {
+		l.Debugf(format, args...)
+	}
+}
+
+func (e *EventLoop) errorf(format string, args ...interface{}) {
+	if l := e.getLogger(); l != nil {
+		l.Errorf(format, args...)
+	}
+}
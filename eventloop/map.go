@@ -0,0 +1,419 @@
+package eventloop
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+// limiter admits batch items. acquire blocks until item i may start and
+// returns false once stop is closed; release is called when item i is done.
+type limiter interface {
+	acquire(i int, stop <-chan struct{}) bool
+	release(i int)
+}
+
+type countLimiter chan struct{}
+
+func (l countLimiter) acquire(_ int, stop <-chan struct{}) bool {
+	select {
+	case l <- struct{}{}:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+func (l countLimiter) release(int) {
+	<-l
+}
+
+// mapOrdered runs fn for every index in [0, n) under lim and returns the
+// results in index order. The first error stops new items from starting.
+func (e *EventLoop) mapOrdered(n int, lim limiter, fn func(i int) (interface{}, error)) (interface{}, error) {
+	results := make([]interface{}, n)
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	var firstErr error
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		if !lim.acquire(i, stop) {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer lim.release(i)
+			value, err := e.Await(e.Async(func() (interface{}, error) {
+				return fn(i)
+			}))
+			if err != nil {
+				stopOnce.Do(func() {
+					firstErr = err
+					close(stop)
+				})
+				return
+			}
+			results[i] = value
+		}(i)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return results, nil
+}
+
+// MapIndexed runs fn over items with at most concurrency calls in flight
+// (unbounded when concurrency <= 0) and resolves with the results in input
+// order. The first error stops new items from starting and rejects the promise.
+func (e *EventLoop) MapIndexed(items []interface{}, concurrency int, fn func(i int, item interface{}) (interface{}, error)) *Promise {
+	return e.coordinate(func() (interface{}, error) {
+		if concurrency <= 0 || concurrency > len(items) {
+			concurrency = len(items)
+		}
+		return e.mapOrdered(len(items), make(countLimiter, concurrency), func(i int) (interface{}, error) {
+			return fn(i, items[i])
+		})
+	})
+}
+
+// MapIndexedContext is MapIndexed that can be aborted: fn gets a context that
+// is cancelled once ctx is done or an item fails, so in-flight items can stop.
+// Once ctx is done no new item starts and the promise rejects with ctx.Err()
+// straight away; results of items still running are discarded. Otherwise the
+// first item to fail rejects it, not the items that stopped because of it.
+func (e *EventLoop) MapIndexedContext(ctx context.Context, items []interface{}, concurrency int, fn func(ctx context.Context, i int, item interface{}) (interface{}, error)) *Promise {
+	return e.coordinate(func() (interface{}, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		parent := ctx
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		if concurrency <= 0 || concurrency > len(items) {
+			concurrency = len(items)
+		}
+		// the first failure is kept before cancelling, so it wins over the
+		// cancellation errors of the items it stops
+		var mu sync.Mutex
+		var failed error
+		done := make(chan SettledResult, 1)
+		go func() {
+			value, err := e.mapOrdered(len(items), make(countLimiter, concurrency), func(i int) (interface{}, error) {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+				value, err := fn(ctx, i, items[i])
+				if err != nil {
+					mu.Lock()
+					if failed == nil {
+						failed = err
+					}
+					mu.Unlock()
+					cancel()
+				}
+				return value, err
+			})
+			if err != nil {
+				mu.Lock()
+				if failed != nil {
+					err = failed
+				}
+				mu.Unlock()
+			}
+			done <- settledResult(value, err)
+		}()
+		select {
+		case r := <-done:
+			return r.Value, r.Err
+		case <-parent.Done():
+			return nil, parent.Err()
+		}
+	})
+}
+
+// FlatMap is MapIndexed for an fn that expands each item into several
+// results: it resolves with all the slices fn returns concatenated, in input
+// order and then in the order of each slice. The first error rejects the
+// promise as in MapIndexed.
+func (e *EventLoop) FlatMap(items []interface{}, concurrency int, fn func(interface{}) ([]interface{}, error)) *Promise {
+	return e.coordinate(func() (interface{}, error) {
+		if concurrency <= 0 || concurrency > len(items) {
+			concurrency = len(items)
+		}
+		parts, err := e.mapOrdered(len(items), make(countLimiter, concurrency), func(i int) (interface{}, error) {
+			return fn(items[i])
+		})
+		if err != nil {
+			return nil, err
+		}
+		flat := []interface{}{}
+		for _, part := range parts.([]interface{}) {
+			flat = append(flat, part.([]interface{})...)
+		}
+		return flat, nil
+	})
+}
+
+// WeightedItem is a MapWeighted input together with its cost.
+type WeightedItem struct {
+	Item   interface{}
+	Weight int64
+}
+
+type weightLimiter struct {
+	items []WeightedItem
+	size  int64
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	cur  int64
+}
+
+func (l *weightLimiter) acquire(i int, stop <-chan struct{}) bool {
+	w := l.items[i].Weight
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.cur+w > l.size {
+		select {
+		case <-stop:
+			return false
+		default:
+		}
+		l.cond.Wait()
+	}
+	select {
+	case <-stop:
+		return false
+	default:
+	}
+	l.cur += w
+	return true
+}
+
+func (l *weightLimiter) release(i int) {
+	l.mu.Lock()
+	l.cur -= l.items[i].Weight
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// MapWeighted is MapIndexed for items of different cost: items start in input
+// order as long as the weights in flight stay within totalWeight. An item
+// heavier than totalWeight, or with a negative weight, rejects the batch
+// before anything runs.
+func (e *EventLoop) MapWeighted(items []WeightedItem, totalWeight int64, fn func(item interface{}) (interface{}, error)) *Promise {
+	return e.coordinate(func() (interface{}, error) {
+		for i, item := range items {
+			if item.Weight < 0 || item.Weight > totalWeight {
+				return nil, fmt.Errorf("eventloop: item %d has weight %d, want 0..%d", i, item.Weight, totalWeight)
+			}
+		}
+		lim := &weightLimiter{items: items, size: totalWeight}
+		lim.cond = sync.NewCond(&lim.mu)
+		return e.mapOrdered(len(items), lim, func(i int) (interface{}, error) {
+			return fn(items[i].Item)
+		})
+	})
+}
+
+// AdaptivePolicy tunes MapAdaptive. Each error multiplies the concurrency by
+// Decrease, and each success adds Increase divided by the current
+// concurrency, so a full round of successes adds about Increase. The result
+// stays within Min and Max. Zero fields take the defaults: Min 1, Max the
+// initial concurrency, Increase 1 and Decrease 0.5.
+type AdaptivePolicy struct {
+	Min      int
+	Max      int
+	Increase float64
+	Decrease float64
+}
+
+type adaptiveLimiter struct {
+	policy AdaptivePolicy
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	limit   float64
+	running int
+}
+
+func (l *adaptiveLimiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.running >= int(l.limit) {
+		l.cond.Wait()
+	}
+	l.running++
+}
+
+func (l *adaptiveLimiter) release(ok bool) {
+	l.mu.Lock()
+	l.running--
+	if ok {
+		l.limit += l.policy.Increase / l.limit
+	} else {
+		l.limit *= l.policy.Decrease
+	}
+	if l.limit < float64(l.policy.Min) {
+		l.limit = float64(l.policy.Min)
+	}
+	if l.limit > float64(l.policy.Max) {
+		l.limit = float64(l.policy.Max)
+	}
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// MapAdaptive runs fn over every item, starting with initialConcurrency calls
+// in flight and adjusting that as errors come and go under policy (AIMD), so a
+// struggling downstream gets fewer requests. Errors do not stop the batch: it
+// resolves with a []SettledResult in input order once every item has run.
+func (e *EventLoop) MapAdaptive(items []interface{}, initialConcurrency int, fn func(item interface{}) (interface{}, error), policy AdaptivePolicy) *Promise {
+	return e.coordinate(func() (interface{}, error) {
+		if initialConcurrency <= 0 {
+			initialConcurrency = 1
+		}
+		if policy.Min <= 0 {
+			policy.Min = 1
+		}
+		if policy.Max <= 0 {
+			policy.Max = initialConcurrency
+		}
+		if policy.Increase <= 0 {
+			policy.Increase = 1
+		}
+		if policy.Decrease <= 0 || policy.Decrease >= 1 {
+			policy.Decrease = 0.5
+		}
+		lim := &adaptiveLimiter{policy: policy, limit: float64(initialConcurrency)}
+		lim.cond = sync.NewCond(&lim.mu)
+
+		results := make([]SettledResult, len(items))
+		var wg sync.WaitGroup
+		for i, item := range items {
+			lim.acquire()
+			wg.Add(1)
+			go func(i int, item interface{}) {
+				defer wg.Done()
+				value, err := e.Await(e.Async(func() (interface{}, error) {
+					return fn(item)
+				}))
+				lim.release(err == nil)
+				results[i] = settledResult(value, err)
+				results[i].Index = i
+			}(i, item)
+		}
+		wg.Wait()
+		return results, nil
+	})
+}
+
+// Pipeline reads items from source until it is closed and runs fn over them
+// with up to workers calls in flight (at least 1). Each outcome is sent on the
+// returned channel as it completes, with Index set to the item's position in
+// source; the channel is unbuffered, so workers wait for the consumer rather
+// than piling results up. The promise resolves with the number of items once
+// all of them are delivered, and the channel is closed then. Errors do not stop
+// the pipeline.
+func (e *EventLoop) Pipeline(source <-chan interface{}, workers int, fn func(interface{}) (interface{}, error)) (<-chan SettledResult, *Promise) {
+	if workers < 1 {
+		workers = 1
+	}
+	out := make(chan SettledResult)
+	type job struct {
+		index int
+		item  interface{}
+	}
+	p := e.coordinate(func() (interface{}, error) {
+		defer close(out)
+		jobs := make(chan job)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := range jobs {
+					j := j
+					r := settledResult(e.Await(e.Async(func() (interface{}, error) {
+						return fn(j.item)
+					})))
+					r.Index = j.index
+					out <- r
+				}
+			}()
+		}
+		n := 0
+		for item := range source {
+			jobs <- job{index: n, item: item}
+			n++
+		}
+		close(jobs)
+		wg.Wait()
+		return n, nil
+	})
+	return out, p
+}
+
+// MapKeyed runs fn over items on a fixed number of serial lanes (at least 1),
+// picking each item's lane by hashing key(item). Items with the same key
+// always share a lane and run one after another in input order, while
+// different lanes run in parallel. It resolves with the results in input
+// order; the first error stops every lane from starting more items and
+// rejects the promise.
+func (e *EventLoop) MapKeyed(items []interface{}, lanes int, key func(interface{}) string, fn func(item interface{}) (interface{}, error)) *Promise {
+	return e.coordinate(func() (interface{}, error) {
+		if lanes < 1 {
+			lanes = 1
+		}
+		queues := make([][]int, lanes)
+		for i, item := range items {
+			h := fnv.New32a()
+			h.Write([]byte(key(item)))
+			lane := int(h.Sum32() % uint32(lanes))
+			queues[lane] = append(queues[lane], i)
+		}
+
+		results := make([]interface{}, len(items))
+		var mu sync.Mutex
+		var firstErr error
+		var wg sync.WaitGroup
+		for _, queue := range queues {
+			if len(queue) == 0 {
+				continue
+			}
+			wg.Add(1)
+			go func(queue []int) {
+				defer wg.Done()
+				for _, i := range queue {
+					mu.Lock()
+					stopped := firstErr != nil
+					mu.Unlock()
+					if stopped {
+						return
+					}
+					item := items[i]
+					value, err := e.Await(e.Async(func() (interface{}, error) {
+						return fn(item)
+					}))
+					mu.Lock()
+					if err != nil && firstErr == nil {
+						firstErr = err
+					}
+					results[i] = value
+					mu.Unlock()
+				}
+			}(queue)
+		}
+		wg.Wait()
+
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		return results, nil
+	})
+}
@@ -0,0 +1,38 @@
+package eventloop
+
+// UseErrorMiddleware adds mw to the chain every error passes through before it
+// reaches a Catch callback: rejection reasons and panics from Then alike. Each
+// middleware wraps the ones added before it, so the last one added is the
+// outermost and gets the error first; the innermost next returns the error
+// unchanged. If the chain returns nil the Catch callback is not called. A
+// panic in a middleware is logged, and the Catch callback gets it in place of
+// the error.
+func (e *EventLoop) UseErrorMiddleware(mw func(next func(error) error) func(error) error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.middleware = append(e.middleware, mw)
+}
+
+func (e *EventLoop) handleError(err error) error {
+	e.mu.RLock()
+	middleware := e.middleware
+	e.mu.RUnlock()
+	if len(middleware) == 0 {
+		return err
+	}
+	out, perr := call(func() (interface{}, error) {
+		handler := func(err error) error {
+			return err
+		}
+		for _, mw := range middleware {
+			handler = mw(handler)
+		}
+		return handler(err), nil
+	})
+	if perr != nil {
+		e.errorf("eventloop: recovered panic in error middleware: %v", perr)
+		return perr
+	}
+	handled, _ := out.(error)
+	return handled
+}
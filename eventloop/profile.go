@@ -0,0 +1,21 @@
+package eventloop
+
+import (
+	"context"
+	"runtime/pprof"
+	"strconv"
+)
+
+// withLabels runs fn under pprof labels naming p, so goroutine and CPU
+// profiles attribute the worker, and goroutines it starts, to the promise.
+// Unnamed promises run fn directly.
+func withLabels(p *Promise, fn func() (interface{}, error)) (value interface{}, err error) {
+	if p.name == "" {
+		return fn()
+	}
+	labels := pprof.Labels("promise", p.name, "promise_id", strconv.FormatUint(p.id, 10))
+	pprof.Do(p.context(), labels, func(context.Context) {
+		value, err = fn()
+	})
+	return value, err
+}
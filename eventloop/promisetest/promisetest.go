@@ -0,0 +1,69 @@
+// Package promisetest has helpers for testing code that returns promises.
+// Every helper fails the test instead of hanging when the promise never
+// settles.
+package promisetest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alob-mtc/go-promise/eventloop"
+)
+
+// Timeout is how long AssertResolves and AssertRejects wait for a promise.
+var Timeout = 5 * time.Second
+
+type result struct {
+	value interface{}
+	err   error
+}
+
+// AwaitForTest awaits p and returns its outcome, failing the test with
+// t.Fatalf if p has not settled within timeout. The outcome counts as
+// consumed, as with Await.
+func AwaitForTest(t testing.TB, p *eventloop.Promise, timeout time.Duration) (interface{}, error) {
+	t.Helper()
+	// buffered so the goroutine exits once p settles after a timeout
+	settled := make(chan result, 1)
+	go func() {
+		value, err := p.Get()
+		settled <- result{value, err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-settled:
+		return r.value, r.err
+	case <-timer.C:
+		t.Fatalf("promise %d did not settle within %s", p.ID(), timeout)
+		return nil, nil
+	}
+}
+
+// AssertResolves fails the test unless p resolves with a value deeply equal
+// to want.
+func AssertResolves(t testing.TB, p *eventloop.Promise, want interface{}) {
+	t.Helper()
+	value, err := AwaitForTest(t, p, Timeout)
+	if err != nil {
+		t.Fatalf("promise %d rejected with %v, want value %#v", p.ID(), err, want)
+	}
+	if !reflect.DeepEqual(value, want) {
+		t.Fatalf("promise %d resolved with %#v, want %#v", p.ID(), value, want)
+	}
+}
+
+// AssertRejects fails the test unless p rejects with an error that matches
+// wantErr under errors.Is. A nil wantErr accepts any rejection.
+func AssertRejects(t testing.TB, p *eventloop.Promise, wantErr error) {
+	t.Helper()
+	value, err := AwaitForTest(t, p, Timeout)
+	if err == nil {
+		t.Fatalf("promise %d resolved with %#v, want rejection", p.ID(), value)
+	}
+	if wantErr != nil && !errors.Is(err, wantErr) {
+		t.Fatalf("promise %d rejected with %v, want %v", p.ID(), err, wantErr)
+	}
+}
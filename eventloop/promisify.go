@@ -0,0 +1,62 @@
+package eventloop
+
+import (
+	"fmt"
+	"reflect"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Promisify adapts a callback-style function, whose last parameter is a
+// callback of the form func(result R, err error), into one that returns a
+// promise, like Node's util.promisify. The promise settles with the first
+// call of the callback: it rejects if err is non-nil and resolves with result
+// otherwise. Arguments must be assignable to fn's parameters, nil meaning the
+// zero value; a mismatch rejects the promise with ErrTypeMismatch. Promisify
+// panics if fn does not have that shape.
+func (e *EventLoop) Promisify(fn interface{}) func(args ...interface{}) *Promise {
+	f := reflect.ValueOf(fn)
+	t := f.Type()
+	if t.Kind() != reflect.Func || t.IsVariadic() || t.NumIn() == 0 {
+		panic(fmt.Sprintf("eventloop: Promisify: want func(..., func(result, error)), got %v", t))
+	}
+	cb := t.In(t.NumIn() - 1)
+	if cb.Kind() != reflect.Func || cb.NumIn() != 2 || cb.NumOut() != 0 || cb.In(1) != errorType {
+		panic(fmt.Sprintf("eventloop: Promisify: last parameter must be func(result, error), got %v", cb))
+	}
+
+	return func(args ...interface{}) *Promise {
+		return e.Async(func() (interface{}, error) {
+			if len(args) != t.NumIn()-1 {
+				return nil, fmt.Errorf("%w: want %d arguments, got %d", ErrTypeMismatch, t.NumIn()-1, len(args))
+			}
+			in := make([]reflect.Value, t.NumIn())
+			for i, arg := range args {
+				want := t.In(i)
+				if arg == nil {
+					in[i] = reflect.Zero(want)
+					continue
+				}
+				v := reflect.ValueOf(arg)
+				if !v.Type().AssignableTo(want) {
+					return nil, fmt.Errorf("%w: argument %d: want %v, got %T", ErrTypeMismatch, i, want, arg)
+				}
+				in[i] = v
+			}
+
+			settled := make(chan SettledResult, 1)
+			in[len(in)-1] = reflect.MakeFunc(cb, func(out []reflect.Value) []reflect.Value {
+				err, _ := out[1].Interface().(error)
+				select {
+				case settled <- settledResult(out[0].Interface(), err):
+				default:
+					// only the first call counts
+				}
+				return nil
+			})
+			f.Call(in)
+			r := <-settled
+			return r.Value, r.Err
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package eventloop
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket allows limit events per second with bursts of up to burst.
+type tokenBucket struct {
+	limit float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token and returns how long to wait before using it.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += now.Sub(b.last).Seconds() * b.limit
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.limit * float64(time.Second))
+}
+
+// cancel returns a reserved token that will not be used.
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens++
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// RateLimited returns a submit function that runs each fn as an Async worker
+// only once a token is available, from a bucket that refills limit tokens per
+// second (limit must be positive) and holds up to burst (at least 1). Calls
+// over the rate wait their turn without holding a worker slot. The module has
+// no dependencies, so this is its own token bucket rather than
+// golang.org/x/time/rate.
+func (e *EventLoop) RateLimited(limit float64, burst int) func(fn func() (interface{}, error)) *Promise {
+	submit := e.RateLimitedContext(limit, burst)
+	return func(fn func() (interface{}, error)) *Promise {
+		return submit(context.Background(), fn)
+	}
+}
+
+// RateLimitedContext is RateLimited with a context per call: if ctx is
+// already done, is done before a token arrives, or its deadline is earlier
+// than the token would be, the promise rejects with the context error and fn
+// does not run. A call with a done ctx takes no token.
+func (e *EventLoop) RateLimitedContext(limit float64, burst int) func(ctx context.Context, fn func() (interface{}, error)) *Promise {
+	if burst < 1 {
+		burst = 1
+	}
+	b := &tokenBucket{limit: limit, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+	return func(ctx context.Context, fn func() (interface{}, error)) *Promise {
+		if err := ctx.Err(); err != nil {
+			return e.RejectedChain(err)
+		}
+		now := time.Now()
+		delay := b.reserve(now)
+		return e.coordinate(func() (interface{}, error) {
+			if deadline, ok := ctx.Deadline(); ok && now.Add(delay).After(deadline) {
+				b.cancel()
+				return nil, context.DeadlineExceeded
+			}
+			if delay > 0 {
+				timer := time.NewTimer(delay)
+				defer timer.Stop()
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					b.cancel()
+					return nil, ctx.Err()
+				}
+			}
+			return e.Await(e.Async(fn))
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package eventloop
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Recorder captures and replays the outcomes of Async work. Keys are the
+// promise name, or "async" when it has none, followed by "#" and how many
+// promises with that name came before it, e.g. "fetch#0", "fetch#1".
+type Recorder interface {
+	// Replay returns the recorded outcome for key; ok false runs the work.
+	Replay(key string) (value interface{}, ok bool, err error)
+	// Record is called with the outcome of work that ran.
+	Record(key string, value interface{}, err error)
+}
+
+// Outcome is a recorded settlement.
+type Outcome struct {
+	Value interface{}
+	Err   error
+}
+
+// MemoryRecorder records every outcome in memory and never replays.
+type MemoryRecorder struct {
+	mu       sync.Mutex
+	outcomes map[string]Outcome
+}
+
+func NewMemoryRecorder() *MemoryRecorder {
+	return &MemoryRecorder{outcomes: map[string]Outcome{}}
+}
+
+func (r *MemoryRecorder) Replay(string) (interface{}, bool, error) {
+	return nil, false, nil
+}
+
+func (r *MemoryRecorder) Record(key string, value interface{}, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.outcomes[key] = Outcome{Value: value, Err: err}
+}
+
+// Outcomes returns a copy of what has been recorded so far, e.g. to save it.
+func (r *MemoryRecorder) Outcomes() map[string]Outcome {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	outcomes := make(map[string]Outcome, len(r.outcomes))
+	for k, v := range r.outcomes {
+		outcomes[k] = v
+	}
+	return outcomes
+}
+
+// NewReplayer returns a Recorder that settles promises with outcomes instead
+// of running their work. Work without a recorded outcome runs normally.
+func NewReplayer(outcomes map[string]Outcome) Recorder {
+	return replayer(outcomes)
+}
+
+type replayer map[string]Outcome
+
+func (r replayer) Replay(key string) (interface{}, bool, error) {
+	o, ok := r[key]
+	return o.Value, ok, o.Err
+}
+
+func (r replayer) Record(string, interface{}, error) {}
+
+// SetRecorder records or replays the outcome of every promise created by Async
+// and its variants through r; a nil r turns it off. Keys depend on the order
+// promises are created in, so a replayed run must create them in the same
+// order as the recorded one.
+func (e *EventLoop) SetRecorder(r Recorder) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.recorder = r
+	e.recordSeq = map[string]int{}
+}
+
+// recorded wraps fn for the recorder, if one is set.
+func (e *EventLoop) recorded(p *Promise, fn func() (interface{}, error)) func() (interface{}, error) {
+	e.mu.Lock()
+	r := e.recorder
+	if r == nil {
+		e.mu.Unlock()
+		return fn
+	}
+	name := p.name
+	if name == "" {
+		name = "async"
+	}
+	key := fmt.Sprintf("%s#%d", name, e.recordSeq[name])
+	e.recordSeq[name]++
+	e.mu.Unlock()
+
+	return func() (interface{}, error) {
+		if value, ok, err := r.Replay(key); ok {
+			return value, err
+		}
+		value, err := fn()
+		r.Record(key, value, err)
+		return value, err
+	}
+}
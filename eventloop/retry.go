@@ -0,0 +1,206 @@
+package eventloop
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrAttemptsExhausted = errors.New("eventloop: retry attempts exhausted")
+
+// BackoffPolicy returns how long to wait before the next attempt, given how
+// many attempts have been made so far. A nil policy retries immediately.
+type BackoffPolicy func(attempt int) time.Duration
+
+// AttemptResult is the value of a RetryUntil, RecoverRetry or Hedge promise
+// once SetAttemptResults is on, so callers can tell how much work the result
+// took.
+type AttemptResult struct {
+	Value    interface{}
+	attempts int
+	hedge    int
+}
+
+// Attempts is how many runs of fn had been started when the result arrived,
+// including the one that produced it.
+func (r AttemptResult) Attempts() int {
+	return r.attempts
+}
+
+// HedgeIndex is which Hedge copy produced the result, 0 being the first; it
+// is always 0 for retries.
+func (r AttemptResult) HedgeIndex() int {
+	return r.hedge
+}
+
+// SetAttemptResults makes RetryUntil, RecoverRetry and Hedge promises created
+// afterwards resolve with an AttemptResult around the value instead of the
+// bare value. It is off by default. Rejections are unchanged.
+func (e *EventLoop) SetAttemptResults(on bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.attempts = on
+}
+
+func (e *EventLoop) wrapAttempts() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.attempts
+}
+
+func attemptResult(wrap bool, value interface{}, attempts, hedge int) interface{} {
+	if !wrap {
+		return value
+	}
+	return AttemptResult{Value: value, attempts: attempts, hedge: hedge}
+}
+
+// ConstantBackoff waits d between attempts.
+func ConstantBackoff(d time.Duration) BackoffPolicy {
+	return func(int) time.Duration {
+		return d
+	}
+}
+
+// ExponentialBackoff doubles the wait after every attempt, starting at base
+// and never exceeding max.
+func ExponentialBackoff(base, max time.Duration) BackoffPolicy {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// RetryUntil runs fn until ok accepts its result and resolves with that
+// result, e.g. to poll a job until it reports done. An error from fn rejects
+// straight away; it is not retried. Once attempts runs out the promise rejects
+// with ErrAttemptsExhausted. attempts <= 0 polls without limit, which is best
+// combined with RetryUntilContext.
+func (e *EventLoop) RetryUntil(fn func() (interface{}, error), ok func(interface{}) bool, attempts int, backoff BackoffPolicy) *Promise {
+	return e.RetryUntilContext(context.Background(), fn, ok, attempts, backoff)
+}
+
+// RetryUntilContext is RetryUntil that stops with ctx.Err() once ctx is done.
+func (e *EventLoop) RetryUntilContext(ctx context.Context, fn func() (interface{}, error), ok func(interface{}) bool, attempts int, backoff BackoffPolicy) *Promise {
+	wrap := e.wrapAttempts()
+	return e.coordinate(func() (interface{}, error) {
+		for attempt := 1; attempts <= 0 || attempt <= attempts; attempt++ {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			value, err := e.Await(e.Async(fn))
+			if err != nil {
+				return nil, err
+			}
+			if ok(value) {
+				return attemptResult(wrap, value, attempt, 0), nil
+			}
+			if attempt != attempts {
+				if err := wait(ctx, backoff, attempt); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return nil, ErrAttemptsExhausted
+	})
+}
+
+// RecoverRetry handles a rejection of p by running fn, a fallback rather than
+// p's own work, up to attempts times with backoff in between (attempts <= 0
+// retries without limit). The derived promise resolves with fn's first
+// success or rejects with its last error; once ctx is done it stops and
+// rejects with ctx.Err(). Values of p pass through, never as an AttemptResult.
+func (p *Promise) RecoverRetry(ctx context.Context, attempts int, backoff BackoffPolicy, fn func() (interface{}, error)) *Promise {
+	wrap := p.loop.wrapAttempts()
+	return p.derive(func(value interface{}, err error) (interface{}, error) {
+		if err == nil || fn == nil {
+			return value, err
+		}
+		for attempt := 1; attempts <= 0 || attempt <= attempts; attempt++ {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			if value, err = p.loop.Await(p.loop.Async(fn)); err == nil {
+				return attemptResult(wrap, value, attempt, 0), nil
+			}
+			if attempt != attempts {
+				if ctxErr := wait(ctx, backoff, attempt); ctxErr != nil {
+					return nil, ctxErr
+				}
+			}
+		}
+		return nil, err
+	})
+}
+
+// wait sleeps for the backoff after attempt, returning ctx.Err() if ctx is
+// done first.
+func wait(ctx context.Context, backoff BackoffPolicy, attempt int) error {
+	if backoff == nil {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(backoff(attempt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Hedge runs fn and, each time after passes without a success, starts another
+// copy, up to maxHedges extra copies, resolving with the first success. A copy
+// that fails starts the next one straight away. Once every copy has failed it
+// rejects with the last error. Copies still running when it settles keep going
+// and their results are discarded, so fn must be safe to run several times
+// at once.
+func (e *EventLoop) Hedge(fn func() (interface{}, error), after time.Duration, maxHedges int) *Promise {
+	if maxHedges < 0 {
+		maxHedges = 0
+	}
+	type hedged struct {
+		SettledResult
+		index int
+	}
+	wrap := e.wrapAttempts()
+	return e.coordinate(func() (interface{}, error) {
+		results := make(chan hedged, maxHedges+1)
+		launched, failed := 0, 0
+		launch := func() {
+			index := launched
+			launched++
+			go func() {
+				results <- hedged{settledResult(e.Await(e.Async(fn))), index}
+			}()
+		}
+		launch()
+		timer := time.NewTimer(after)
+		defer timer.Stop()
+		for {
+			select {
+			case r := <-results:
+				if r.Err == nil {
+					return attemptResult(wrap, r.Value, launched, r.index), nil
+				}
+				failed++
+				if launched <= maxHedges {
+					launch()
+				} else if failed == launched {
+					return nil, r.Err
+				}
+			case <-timer.C:
+				if launched <= maxHedges {
+					launch()
+					timer.Reset(after)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,315 @@
+package eventloop
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"runtime"
+	"sync"
+	"time"
+)
+
+var ErrQueueFull = errors.New("eventloop: queue is full")
+
+// scheduler starts Async workers, at most limit at a time when limit > 0.
+// Workers over the limit wait in FIFO order.
+type scheduler struct {
+	mu       sync.Mutex
+	limit    int
+	maxQueue int
+	running  int
+	paused   bool
+	queue    []queued
+
+	// weighted-fair picking across tags, on once a weight is set: each tag's
+	// pass grows by 1/weight per task started, and the lowest pass goes next
+	weights map[string]int
+	pass    map[string]float64
+	vtime   float64
+	rng     *rand.Rand // set by SetExecutionSeed; overrides the order above
+
+	// queue metrics, updated with s.mu held as tasks are queued and popped
+	peak      int
+	waited    uint64
+	totalWait time.Duration
+	maxWait   time.Duration
+}
+
+// queued is a task waiting for a slot, or a yielded worker waiting to get one
+// back, in which case resume is closed instead of running anything.
+type queued struct {
+	task   func()
+	resume chan struct{}
+	tag    string
+	at     time.Time
+}
+
+// SetMaxConcurrency bounds how many Async workers run at once; the rest wait
+// for a free slot in the order they were created. n <= 0 removes the bound,
+// which is the default. Then callbacks and the promises made by combinators
+// only wait on other promises and never take a slot, but a worker that awaits
+// another Async promise keeps its slot while it waits.
+func (e *EventLoop) SetMaxConcurrency(n int) {
+	s := &e.sched
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit = n
+	s.startQueued()
+}
+
+// Resize changes the concurrency bound at runtime, e.g. on a config reload,
+// without losing work. Growing starts queued workers straight away;
+// shrinking starts nothing new until the running workers drop below n, and
+// lets them finish. Workers are goroutines started per task, so there is no
+// pool to resize beyond the bound itself. Resize is SetMaxConcurrency under
+// the name callers look for.
+func (e *EventLoop) Resize(n int) {
+	e.SetMaxConcurrency(n)
+}
+
+// Pause stops Async workers from starting: new ones are queued, subject to
+// SetMaxQueue, and workers already running carry on. Pausing twice is the
+// same as pausing once.
+func (e *EventLoop) Pause() {
+	s := &e.sched
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = true
+}
+
+// Resume starts the workers queued while paused, in the order they were
+// created and within SetMaxConcurrency.
+func (e *EventLoop) Resume() {
+	s := &e.sched
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = false
+	s.startQueued()
+}
+
+// startQueued starts queued tasks while there are free slots; s.mu is held.
+func (s *scheduler) startQueued() {
+	for len(s.queue) > 0 && s.free() {
+		s.running++
+		if q := s.pop(); q.resume != nil {
+			close(q.resume)
+		} else {
+			go s.run(q.task)
+		}
+	}
+}
+
+func (s *scheduler) free() bool {
+	return !s.paused && (s.limit <= 0 || s.running < s.limit)
+}
+
+// SetMaxQueue bounds how many Async workers may wait for a slot. Past that,
+// Async returns a promise rejected with ErrQueueFull instead of queueing more
+// work. It only matters together with SetMaxConcurrency or Pause, since
+// otherwise workers never wait. n <= 0 removes the bound, which is the default.
+func (e *EventLoop) SetMaxQueue(n int) {
+	s := &e.sched
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxQueue = n
+}
+
+// SetTagWeight switches queued Async workers from FIFO to weighted-fair order
+// across tags (see AsyncTagged): while workers wait for a slot, each tag gets
+// slots in proportion to its weight, so a busy tag cannot starve the others.
+// Workers of the same tag still start in the order they were created. Tags
+// without a weight, untagged workers among them, weigh 1, as do workers
+// resuming after Yield; weight <= 0 resets tag to 1.
+func (e *EventLoop) SetTagWeight(tag string, weight int) {
+	s := &e.sched
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.weights == nil {
+		s.weights = make(map[string]int)
+		s.pass = make(map[string]float64)
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+	s.weights[tag] = weight
+}
+
+// SetExecutionSeed is a chaos mode for tests that shakes out code relying on
+// incidental ordering. Queued Async workers are started in a random order
+// instead of FIFO or SetTagWeight's, and with SetSerialCallbacks the executor
+// runs waiting callbacks in a random order as well; without it callbacks run
+// concurrently anyway. The choices come from seed, so a run that queues the
+// same work the same way makes the same choices again, though goroutine
+// timing can still differ between runs. Guarantees that do not depend on
+// execution order, such as the result order of Gather, are unaffected. There
+// is no way back to the normal order; use it on a loop made for the test.
+func (e *EventLoop) SetExecutionSeed(seed int64) {
+	s := &e.sched
+	s.mu.Lock()
+	s.rng = rand.New(rand.NewSource(seed))
+	s.mu.Unlock()
+	q := &e.callbacks
+	q.mu.Lock()
+	q.rng = rand.New(rand.NewSource(seed))
+	q.mu.Unlock()
+}
+
+// submit starts task or queues it, and reports false if the queue is full.
+func (s *scheduler) submit(tag string, task func()) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.free() {
+		s.running++
+		go s.run(task)
+		return true
+	}
+	if s.maxQueue > 0 && len(s.queue) >= s.maxQueue {
+		return false
+	}
+	s.push(queued{task: task, tag: tag})
+	return true
+}
+
+// run executes task and then keeps taking queued tasks while its slot is
+// still within the limit. Handing the slot to a yielded worker ends the run.
+func (s *scheduler) run(task func()) {
+	for task != nil {
+		task()
+		s.mu.Lock()
+		if len(s.queue) > 0 && !s.paused && (s.limit <= 0 || s.running <= s.limit) {
+			q := s.pop()
+			task = q.task
+			if q.resume != nil {
+				close(q.resume)
+			}
+		} else {
+			s.running--
+			task = nil
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *scheduler) push(q queued) {
+	q.at = time.Now()
+	// a tag that was idle does not get to catch up on the slots it missed
+	if s.weights != nil && s.pass[q.tag] < s.vtime {
+		s.pass[q.tag] = s.vtime
+	}
+	s.queue = append(s.queue, q)
+	if len(s.queue) > s.peak {
+		s.peak = len(s.queue)
+	}
+}
+
+func (s *scheduler) pop() queued {
+	next := 0
+	switch {
+	case s.rng != nil:
+		next = s.rng.Intn(len(s.queue))
+	case s.weights != nil:
+		next = s.fairNext()
+	}
+	q := s.queue[next]
+	if next == 0 {
+		s.queue[0] = queued{}
+		s.queue = s.queue[1:]
+	} else {
+		copy(s.queue[next:], s.queue[next+1:])
+		s.queue[len(s.queue)-1] = queued{}
+		s.queue = s.queue[:len(s.queue)-1]
+	}
+	if s.weights != nil {
+		weight := s.weights[q.tag]
+		if weight <= 0 {
+			weight = 1
+		}
+		s.vtime = s.pass[q.tag]
+		s.pass[q.tag] += 1 / float64(weight)
+	}
+	wait := time.Since(q.at)
+	s.waited++
+	s.totalWait += wait
+	if wait > s.maxWait {
+		s.maxWait = wait
+	}
+	return q
+}
+
+// fairNext returns the index of the first queued entry of the tag with the
+// lowest pass.
+func (s *scheduler) fairNext() int {
+	next := 0
+	for i, q := range s.queue {
+		if s.pass[q.tag] < s.pass[s.queue[next].tag] {
+			next = i
+		}
+	}
+	return next
+}
+
+// Stats describes the Async workers of a loop, for sizing SetMaxConcurrency.
+// Queued work includes workers that gave their slot up through Yield.
+type Stats struct {
+	Running        int           // workers holding a slot
+	QueueDepth     int           // workers waiting for a slot
+	PeakQueueDepth int           // the largest QueueDepth so far
+	Dequeued       uint64        // workers that waited and then got a slot
+	TotalWait      time.Duration // the time those workers spent waiting
+	MaxWait        time.Duration // the longest of those waits
+}
+
+// AverageWait is TotalWait spread over Dequeued, or 0 if nothing has waited.
+func (s Stats) AverageWait() time.Duration {
+	if s.Dequeued == 0 {
+		return 0
+	}
+	return s.TotalWait / time.Duration(s.Dequeued)
+}
+
+// Stats returns a snapshot of the loop's worker scheduling.
+func (e *EventLoop) Stats() Stats {
+	s := &e.sched
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Stats{
+		Running:        s.running,
+		QueueDepth:     len(s.queue),
+		PeakQueueDepth: s.peak,
+		Dequeued:       s.waited,
+		TotalWait:      s.totalWait,
+		MaxWait:        s.maxWait,
+	}
+}
+
+// yield gives the caller's slot to the next queued task and waits at the back
+// of the queue to get one back. The caller must hold a slot.
+func (s *scheduler) yield() bool {
+	s.mu.Lock()
+	if len(s.queue) == 0 || s.paused {
+		s.mu.Unlock()
+		return false
+	}
+	resume := make(chan struct{})
+	s.running--
+	s.push(queued{resume: resume})
+	s.startQueued()
+	s.mu.Unlock()
+	<-resume
+	return true
+}
+
+type workerKey struct{}
+
+// Yield lets other work run before the rest of the caller's. It is meant for
+// CPU-bound AsyncContext workers that split their work into chunks, called
+// with the ctx the worker received and from the worker's own goroutine. When
+// Async workers are waiting for a slot, under SetMaxConcurrency, the caller
+// gives its slot to the first of them and queues behind the others; otherwise
+// it only yields the processor. Any other ctx only yields the processor.
+func Yield(ctx context.Context) {
+	if e, ok := ctx.Value(workerKey{}).(*EventLoop); ok && e.sched.yield() {
+		return
+	}
+	runtime.Gosched()
+}
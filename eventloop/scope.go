@@ -0,0 +1,46 @@
+package eventloop
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WithScope names the part of a pipeline p belongs to. Stages derived from p,
+// through ThenMap and the like, add their own scope to p's, and the loop's
+// logs, spans and PendingPromises show the whole path, e.g.
+// "fetch/parse/validate". A promise's scope is set once; later calls are
+// ignored. It returns p.
+func (p *Promise) WithScope(scope string) *Promise {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.scope == "" {
+		p.scope = scope
+	}
+	return p
+}
+
+// Scope returns p's scope path: the scopes of the promises p was derived
+// from, outermost first, followed by its own.
+func (p *Promise) Scope() string {
+	var parts []string
+	for q := p; q != nil; q = q.parent {
+		q.mu.Lock()
+		scope := q.scope
+		q.mu.Unlock()
+		if scope != "" {
+			parts = append(parts, scope)
+		}
+	}
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(parts, "/")
+}
+
+// ref names p in log messages.
+func (p *Promise) ref() string {
+	if path := p.Scope(); path != "" {
+		return fmt.Sprintf("promise %d (%s)", p.id, path)
+	}
+	return fmt.Sprintf("promise %d", p.id)
+}
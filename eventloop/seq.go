@@ -0,0 +1,88 @@
+package eventloop
+
+import "sync"
+
+// The iterator types below are those of iter.Seq[interface{}] and
+// iter.Seq2[interface{}, error], spelled out so the module keeps building on
+// Go versions without the iter package; the iter types convert implicitly.
+
+// FromSeq runs fn over every item seq yields, with at most concurrency calls
+// in flight (unbounded when concurrency <= 0), and resolves with the results
+// in the order seq yielded the items. The first error stops seq from being
+// read further and rejects the promise once the calls already started return.
+func (e *EventLoop) FromSeq(seq func(yield func(interface{}) bool), concurrency int, fn func(interface{}) (interface{}, error)) *Promise {
+	return e.coordinate(func() (interface{}, error) {
+		var lim countLimiter
+		if concurrency > 0 {
+			lim = make(countLimiter, concurrency)
+		}
+		var mu sync.Mutex
+		var results []interface{}
+		stop := make(chan struct{})
+		var stopOnce sync.Once
+		var firstErr error
+		var wg sync.WaitGroup
+		n := 0
+
+		seq(func(item interface{}) bool {
+			i := n
+			if lim != nil && !lim.acquire(i, stop) {
+				return false
+			}
+			select {
+			case <-stop:
+				if lim != nil {
+					lim.release(i)
+				}
+				return false
+			default:
+			}
+			n++
+			mu.Lock()
+			results = append(results, nil)
+			mu.Unlock()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if lim != nil {
+					defer lim.release(i)
+				}
+				value, err := e.Await(e.Async(func() (interface{}, error) {
+					return fn(item)
+				}))
+				if err != nil {
+					stopOnce.Do(func() {
+						firstErr = err
+						close(stop)
+					})
+					return
+				}
+				mu.Lock()
+				results[i] = value
+				mu.Unlock()
+			}()
+			return true
+		})
+		wg.Wait()
+
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		return results, nil
+	})
+}
+
+// ResultSeq yields the outcome of each of promises in the order they settle,
+// like AsCompleted. Stopping the range early abandons the promises not yet
+// yielded like the losers of Some.
+func (e *EventLoop) ResultSeq(promises []*Promise) func(yield func(interface{}, error) bool) {
+	return func(yield func(interface{}, error) bool) {
+		settled, abandon := outcomes(promises)
+		defer abandon()
+		for r := range settled {
+			if !yield(r.Value, r.Err) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package eventloop
+
+import (
+	"os"
+	"os/signal"
+)
+
+// FromSignal returns a promise that resolves with the first of sigs the
+// process receives. The notification is removed with signal.Stop as soon as
+// the promise settles, whether by a signal, a timeout or the loop's context,
+// so later signals get their default handling again.
+func (e *EventLoop) FromSignal(sigs ...os.Signal) *Promise {
+	ch := make(chan os.Signal, 1)
+	stop := make(chan struct{})
+	signal.Notify(ch, sigs...)
+	p := e.coordinate(func() (interface{}, error) {
+		defer signal.Stop(ch)
+		select {
+		case sig := <-ch:
+			return sig, nil
+		case <-stop:
+			return nil, nil
+		}
+	})
+	go func() {
+		<-p.settled
+		signal.Stop(ch)
+		close(stop)
+	}()
+	return p
+}
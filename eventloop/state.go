@@ -0,0 +1,74 @@
+package eventloop
+
+import "sync"
+
+// SharedState is a key/value store that Then callbacks, derived stages and
+// Map workers of a pipeline can share without their own locking. Values are
+// stored as given, so a value that is itself mutated still needs its own
+// synchronisation; Update is the way to change one safely.
+type SharedState struct {
+	mu     sync.Mutex
+	values map[string]interface{}
+}
+
+// WithSharedState returns an empty SharedState for a pipeline on e.
+func (e *EventLoop) WithSharedState() *SharedState {
+	return &SharedState{values: make(map[string]interface{})}
+}
+
+// Load returns the value stored under key and whether there is one.
+func (s *SharedState) Load(key string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	return value, ok
+}
+
+// Store sets the value under key.
+func (s *SharedState) Store(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+}
+
+// Delete removes key.
+func (s *SharedState) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+}
+
+// Update replaces the value under key with fn's result, with no other access
+// to s in between, and returns it. fn gets nil and false when key is unset.
+// fn must not use s itself.
+func (s *SharedState) Update(key string, fn func(old interface{}, ok bool) interface{}) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.values[key]
+	value := fn(old, ok)
+	s.values[key] = value
+	return value
+}
+
+// Add adds delta to the int64 counter under key, starting from 0, and
+// returns the new count. It panics if key holds something other than an
+// int64.
+func (s *SharedState) Add(key string, delta int64) int64 {
+	return s.Update(key, func(old interface{}, ok bool) interface{} {
+		if !ok {
+			return delta
+		}
+		return old.(int64) + delta
+	}).(int64)
+}
+
+// Snapshot returns a copy of every key and value in s.
+func (s *SharedState) Snapshot() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := make(map[string]interface{}, len(s.values))
+	for k, v := range s.values {
+		snapshot[k] = v
+	}
+	return snapshot
+}
@@ -0,0 +1,64 @@
+package eventloop
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RestartPolicy decides whether Supervise restarts its work after a failure.
+// A policy may keep state, so give each Supervise call its own.
+type RestartPolicy interface {
+	Restart(err error, now time.Time) bool
+}
+
+// MaxRestarts allows at most n restarts within any window, like an Erlang
+// supervisor's intensity and period; a window <= 0 counts every restart.
+func MaxRestarts(n int, window time.Duration) RestartPolicy {
+	return &maxRestarts{n: n, window: window}
+}
+
+type maxRestarts struct {
+	n      int
+	window time.Duration
+
+	mu       sync.Mutex
+	restarts []time.Time
+}
+
+func (m *maxRestarts) Restart(_ error, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.window > 0 {
+		kept := m.restarts[:0]
+		for _, t := range m.restarts {
+			if now.Sub(t) < m.window {
+				kept = append(kept, t)
+			}
+		}
+		m.restarts = kept
+	}
+	if len(m.restarts) >= m.n {
+		return false
+	}
+	m.restarts = append(m.restarts, now)
+	return true
+}
+
+// Supervise runs fn and restarts it each time it returns an error or panics,
+// for as long as policy allows. It resolves with fn's first successful result
+// and rejects with the last failure once policy refuses a restart.
+func (e *EventLoop) Supervise(fn func() (interface{}, error), policy RestartPolicy) *Promise {
+	return e.coordinate(func() (interface{}, error) {
+		for restarts := 0; ; restarts++ {
+			value, err := e.Await(e.Async(fn))
+			if err == nil {
+				return value, nil
+			}
+			if !policy.Restart(err, time.Now()) {
+				return nil, fmt.Errorf("eventloop: supervise: giving up after %d restarts: %w", restarts, err)
+			}
+			e.debugf("eventloop: supervise: restarting after: %v", err)
+		}
+	})
+}
@@ -0,0 +1,31 @@
+package eventloop
+
+// AsyncTagged is Async with a tag that groups the promise with others for
+// AwaitTag.
+func (e *EventLoop) AsyncTagged(tag string, fn func() (interface{}, error)) *Promise {
+	return e.runAsync(e.add(&Promise{tag: tag}), fn)
+}
+
+// AwaitTag blocks until every promise tagged tag has settled, including ones
+// created while it waits, and returns the first rejection among them in
+// creation order. It awaits each of them, so their rejections count as
+// handled.
+func (e *EventLoop) AwaitTag(tag string) error {
+	var firstErr error
+	seen := 0
+	for {
+		queue := e.queue()
+		if seen == len(queue) {
+			return firstErr
+		}
+		for _, p := range queue[seen:] {
+			if p.tag != tag {
+				continue
+			}
+			if _, err := e.Await(p); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		seen = len(queue)
+	}
+}
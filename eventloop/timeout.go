@@ -0,0 +1,37 @@
+package eventloop
+
+import "time"
+
+// SetDefaultTimeout gives every promise created by Async or AsyncNamed a
+// WithTimeout(d), so all work has a deadline without wrapping each call. An
+// explicit WithTimeout on the promise replaces it. d <= 0 turns the default
+// off, which is the default.
+func (e *EventLoop) SetDefaultTimeout(d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.timeout = d
+}
+
+func (e *EventLoop) defaultTimeout() time.Duration {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.timeout
+}
+
+// WithTimeout rejects p with ErrTimeout if it has not settled within d of the
+// call, replacing any earlier deadline on p; d <= 0 removes the deadline. The
+// work itself keeps running and its late result is discarded. It returns p.
+func (p *Promise) WithTimeout(d time.Duration) *Promise {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.deadline != nil {
+		p.deadline.Stop()
+		p.deadline = nil
+	}
+	if d > 0 {
+		p.deadline = time.AfterFunc(d, func() {
+			p.loop.promiseRecovery(p, p.loop.deliveryMode())(nil, ErrTimeout)
+		})
+	}
+	return p
+}
@@ -0,0 +1,57 @@
+package eventloop_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alob-mtc/go-promise/eventloop"
+	"github.com/alob-mtc/go-promise/eventloop/promisetest"
+)
+
+func TestWithDeadlinesSoftOnly(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	var soft int32
+	p := e.Async(func() (interface{}, error) {
+		time.Sleep(50 * time.Millisecond)
+		return 1, nil
+	}).WithDeadlines(10*time.Millisecond, time.Second, func() {
+		atomic.AddInt32(&soft, 1)
+	})
+	promisetest.AssertResolves(t, p, 1)
+	if n := atomic.LoadInt32(&soft); n != 1 {
+		t.Fatalf("onSoft ran %d times, want 1", n)
+	}
+}
+
+func TestWithDeadlinesSoftAndHard(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	block := make(chan struct{})
+	defer close(block)
+	var soft int32
+	p := e.Async(func() (interface{}, error) {
+		<-block
+		return 1, nil
+	}).WithDeadlines(10*time.Millisecond, 30*time.Millisecond, func() {
+		atomic.AddInt32(&soft, 1)
+	})
+	promisetest.AssertRejects(t, p, eventloop.ErrTimeout)
+	if n := atomic.LoadInt32(&soft); n != 1 {
+		t.Fatalf("onSoft ran %d times, want 1", n)
+	}
+}
+
+func TestWithDeadlinesSettledEarly(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	var soft int32
+	p := e.Async(func() (interface{}, error) {
+		return 1, nil
+	}).WithDeadlines(10*time.Millisecond, 20*time.Millisecond, func() {
+		atomic.AddInt32(&soft, 1)
+	})
+	promisetest.AssertResolves(t, p, 1)
+	time.Sleep(30 * time.Millisecond)
+	if n := atomic.LoadInt32(&soft); n != 0 {
+		t.Fatalf("onSoft ran %d times after the promise settled", n)
+	}
+}
@@ -0,0 +1,163 @@
+package eventloop
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrConditionFalse  = errors.New("eventloop: condition no longer holds")
+	ErrInvalidInterval = errors.New("eventloop: poll interval must be positive")
+)
+
+// Interval runs fn every d and signals each result on the returned future,
+// like setInterval: a value as a completion event, recorded whether or not a
+// complete function is registered, and an error or panic as an error event.
+// The returned function stops the ticker and closes the future, as does
+// f.Close; it is safe to call more than once.
+func (e *EventLoop) Interval(d time.Duration, fn func() (interface{}, error)) (*Future, func()) {
+	f := e.NewFuture()
+	ticker := time.NewTicker(d)
+	stop := make(chan struct{})
+	f.mu.Lock()
+	f.teardown = func() {
+		close(stop)
+	}
+	f.mu.Unlock()
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				value, err := call(fn)
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err != nil {
+					f.RejectFuture(err)
+					continue
+				}
+				handler, listeners := f.snapshot()
+				f.emit(handler, listeners, value)
+			}
+		}
+	}()
+
+	return f, f.Close
+}
+
+// ScheduleAt runs fn at t and resolves with its result; if t has already
+// passed, fn runs straight away. The wait holds no worker slot. If the
+// promise settles during the wait, e.g. through WithTimeout, or on a loop made
+// by NewEventLoopWithContext once the loop's context is done, the wait ends,
+// fn does not run and the timer is stopped.
+func (e *EventLoop) ScheduleAt(t time.Time, fn func() (interface{}, error)) *Promise {
+	p := e.newPromise("")
+	e.run(p, false, func() (interface{}, error) {
+		if d := time.Until(t); d > 0 {
+			timer := time.NewTimer(d)
+			defer timer.Stop()
+			var done <-chan struct{}
+			if e.ctx != nil {
+				done = e.ctx.Done()
+			}
+			select {
+			case <-timer.C:
+			case <-p.settled:
+				return nil, nil
+			case <-done:
+				return nil, e.ctx.Err()
+			}
+		}
+		return e.Await(e.Async(fn))
+	})
+	return p
+}
+
+// Poll calls fn straight away and then every interval, each call as an Async
+// worker, until fn reports done and the promise resolves with its value. An
+// error from fn rejects straight away, and once ctx is done Poll stops and
+// rejects with ctx.Err(). The ticker is stopped when the promise settles.
+func (e *EventLoop) Poll(ctx context.Context, interval time.Duration, fn func() (done bool, value interface{}, err error)) *Promise {
+	type poll struct {
+		done  bool
+		value interface{}
+	}
+	return e.coordinate(func() (interface{}, error) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			result, err := e.Await(e.Async(func() (interface{}, error) {
+				done, value, err := fn()
+				return poll{done, value}, err
+			}))
+			if err != nil {
+				return nil, err
+			}
+			if r := result.(poll); r.done {
+				return r.value, nil
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	})
+}
+
+// AsyncWhile is AsyncContext for work that may only go on while cond holds,
+// e.g. while a feature flag is on or a lease is valid. cond is checked before
+// fn starts and then every pollInterval; once it returns false, or panics,
+// the promise rejects with ErrConditionFalse, or the panic, and ctx is
+// cancelled so fn can stop. fn's late result is discarded. The poller stops
+// and ctx is cancelled when the promise settles. A pollInterval <= 0 rejects
+// with ErrInvalidInterval without running fn.
+func (e *EventLoop) AsyncWhile(cond func() bool, pollInterval time.Duration, fn func(context.Context) (interface{}, error)) *Promise {
+	if pollInterval <= 0 {
+		return e.RejectedChain(ErrInvalidInterval)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	holds := func() error {
+		ok, err := call(func() (interface{}, error) {
+			return cond(), nil
+		})
+		if err == nil && !ok.(bool) {
+			err = ErrConditionFalse
+		}
+		return err
+	}
+	p := e.AsyncContext(ctx, func(ctx context.Context) (interface{}, error) {
+		if err := holds(); err != nil {
+			return nil, err
+		}
+		return fn(ctx)
+	})
+	go func() {
+		defer cancel()
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.settled:
+				return
+			case <-ticker.C:
+				if err := holds(); err != nil {
+					cancel()
+					e.promiseRecovery(p, e.deliveryMode())(nil, err)
+					return
+				}
+			}
+		}
+	}()
+	return p
+}
@@ -0,0 +1,46 @@
+package eventloop_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alob-mtc/go-promise/eventloop"
+	"github.com/alob-mtc/go-promise/eventloop/promisetest"
+)
+
+func TestPollDoneAfterN(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	polls := 0
+	p := e.Poll(context.Background(), time.Millisecond, func() (bool, interface{}, error) {
+		polls++
+		return polls == 3, polls, nil
+	})
+	promisetest.AssertResolves(t, p, 3)
+}
+
+func TestPollErrorMidPoll(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	polls := 0
+	p := e.Poll(context.Background(), time.Millisecond, func() (bool, interface{}, error) {
+		polls++
+		if polls == 2 {
+			return false, nil, errBoom
+		}
+		return false, nil, nil
+	})
+	promisetest.AssertRejects(t, p, errBoom)
+	if polls != 2 {
+		t.Fatalf("fn ran %d times, want 2", polls)
+	}
+}
+
+func TestPollContextCancel(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	p := e.Poll(ctx, time.Millisecond, func() (bool, interface{}, error) {
+		return false, nil, nil
+	})
+	time.AfterFunc(10*time.Millisecond, cancel)
+	promisetest.AssertRejects(t, p, context.Canceled)
+}
@@ -0,0 +1,61 @@
+package eventloop
+
+import "fmt"
+
+// Tracer starts a span for each stage of a named promise: one for the
+// AsyncNamed work, one per Then callback and one per stage derived from it,
+// such as ThenMap. The returned function ends the
+// span with the stage's error, nil on success.
+type Tracer interface {
+	StartSpan(name string) (Span, func(error))
+}
+
+// Span is the part of a tracing span the loop uses to tag it with the
+// promise it belongs to.
+type Span interface {
+	SetAttribute(key string, value interface{})
+}
+
+// SetTracer installs t on the loop; a nil tracer turns tracing off. Only
+// promises with a name are traced.
+func (e *EventLoop) SetTracer(t Tracer) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.tracer = t
+}
+
+func (e *EventLoop) getTracer() Tracer {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.tracer
+}
+
+func endNoop(error) {}
+
+// startSpan starts the span for a stage of p, where stage 0 is the promise's
+// own work. It costs nothing beyond the checks when p has no name or no
+// tracer is set.
+func (e *EventLoop) startSpan(p *Promise, stage int) func(error) {
+	if p.name == "" {
+		return endNoop
+	}
+	t := e.getTracer()
+	if t == nil {
+		return endNoop
+	}
+	name := p.name
+	if stage > 0 {
+		name = fmt.Sprintf("%s.then[%d]", p.name, stage)
+	}
+	span, end := t.StartSpan(name)
+	if span != nil {
+		span.SetAttribute("promise.id", p.id)
+		if path := p.Scope(); path != "" {
+			span.SetAttribute("promise.scope", path)
+		}
+	}
+	if end == nil {
+		return endNoop
+	}
+	return end
+}
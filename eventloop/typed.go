@@ -0,0 +1,182 @@
+package eventloop
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var ErrTypeMismatch = errors.New("eventloop: result has unexpected type")
+
+// AwaitValue awaits p and asserts its value to T. ok is false when the
+// promise rejected or the value is not a T; err says which.
+func AwaitValue[T any](e *EventLoop, p *Promise) (T, bool, error) {
+	var zero T
+	value, err := e.Await(p)
+	if err != nil {
+		return zero, false, err
+	}
+	v, err := as[T](value)
+	if err != nil {
+		return zero, false, err
+	}
+	return v, true, nil
+}
+
+// as asserts value to T, naming both types in the error.
+func as[T any](value interface{}) (T, error) {
+	v, ok := value.(T)
+	if !ok {
+		return v, fmt.Errorf("%w: want %v, got %T", ErrTypeMismatch, reflect.TypeOf((*T)(nil)).Elem(), value)
+	}
+	return v, nil
+}
+
+// TypedPromise is a Promise whose value is known to be a T.
+type TypedPromise[T any] struct {
+	*Promise
+}
+
+// Typed views p as resolving with a T; Get rejects with ErrTypeMismatch if it
+// does not.
+func Typed[T any](p *Promise) *TypedPromise[T] {
+	return &TypedPromise[T]{Promise: p}
+}
+
+// Get awaits the promise and returns its value as a T.
+func (p *TypedPromise[T]) Get() (T, error) {
+	v, _, err := AwaitValue[T](p.loop, p.Promise)
+	return v, err
+}
+
+type Pair[A, B any] struct {
+	First  A
+	Second B
+}
+
+type Triple[A, B, C any] struct {
+	First  A
+	Second B
+	Third  C
+}
+
+// zip awaits promises and returns their values in order, or the first
+// rejection as soon as it happens, abandoning the rest like the losers of
+// Some.
+func zip(e *EventLoop, promises ...*Promise) ([]interface{}, error) {
+	values := make([]interface{}, len(promises))
+	settled, abandon := outcomes(promises)
+	defer abandon()
+	for r := range settled {
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		values[r.Index] = r.Value
+	}
+	return values, nil
+}
+
+// Zip2 resolves with the values of pa and pb once both resolve, and rejects
+// with the first rejection, or ErrTypeMismatch for a value of the wrong type.
+// The other promise is abandoned after a rejection like the losers of Some.
+func Zip2[A, B any](e *EventLoop, pa *TypedPromise[A], pb *TypedPromise[B]) *TypedPromise[Pair[A, B]] {
+	return Typed[Pair[A, B]](e.coordinate(func() (interface{}, error) {
+		values, err := zip(e, pa.Promise, pb.Promise)
+		if err != nil {
+			return nil, err
+		}
+		var pair Pair[A, B]
+		if pair.First, err = as[A](values[0]); err != nil {
+			return nil, err
+		}
+		if pair.Second, err = as[B](values[1]); err != nil {
+			return nil, err
+		}
+		return pair, nil
+	}))
+}
+
+// Zip3 is Zip2 for three promises.
+func Zip3[A, B, C any](e *EventLoop, pa *TypedPromise[A], pb *TypedPromise[B], pc *TypedPromise[C]) *TypedPromise[Triple[A, B, C]] {
+	return Typed[Triple[A, B, C]](e.coordinate(func() (interface{}, error) {
+		values, err := zip(e, pa.Promise, pb.Promise, pc.Promise)
+		if err != nil {
+			return nil, err
+		}
+		var triple Triple[A, B, C]
+		if triple.First, err = as[A](values[0]); err != nil {
+			return nil, err
+		}
+		if triple.Second, err = as[B](values[1]); err != nil {
+			return nil, err
+		}
+		if triple.Third, err = as[C](values[2]); err != nil {
+			return nil, err
+		}
+		return triple, nil
+	}))
+}
+
+// Envelope carries a value together with metadata that accumulates as it
+// passes through ThenEnvelope stages.
+type Envelope[T any] struct {
+	Value T
+	Meta  map[string]interface{}
+}
+
+// ThenEnvelope resolves the derived promise with an Envelope[U] holding
+// fn's result. fn gets the incoming value and a copy of its metadata, which it
+// may add to; the copy becomes the new envelope's Meta. p may resolve with an
+// Envelope[T] or a bare T, which starts with empty metadata; anything else
+// rejects with ErrTypeMismatch. A rejection of p skips fn.
+func ThenEnvelope[T, U any](p *Promise, fn func(value T, meta map[string]interface{}) (U, error)) *Promise {
+	return p.derive(func(value interface{}, err error) (interface{}, error) {
+		if err != nil {
+			return nil, err
+		}
+		var in Envelope[T]
+		switch v := value.(type) {
+		case Envelope[T]:
+			in = v
+		case T:
+			in.Value = v
+		default:
+			return nil, fmt.Errorf("%w: want %v, got %T", ErrTypeMismatch, reflect.TypeOf((*Envelope[T])(nil)).Elem(), value)
+		}
+		meta := make(map[string]interface{}, len(in.Meta))
+		for k, v := range in.Meta {
+			meta[k] = v
+		}
+		out, err := fn(in.Value, meta)
+		if err != nil {
+			return nil, err
+		}
+		return Envelope[U]{Value: out, Meta: meta}, nil
+	})
+}
+
+// CollectMap runs fn for every key with at most concurrency calls in flight
+// (unbounded when concurrency <= 0) and resolves with a map from each key to
+// its value. The first error stops new calls from starting and rejects. A key
+// that appears more than once in items runs once per appearance, and the last
+// of them in items wins.
+func CollectMap[K comparable, V any](e *EventLoop, items []K, concurrency int, fn func(K) (V, error)) *TypedPromise[map[K]V] {
+	return Typed[map[K]V](e.coordinate(func() (interface{}, error) {
+		if concurrency <= 0 || concurrency > len(items) {
+			concurrency = len(items)
+		}
+		values, err := e.mapOrdered(len(items), make(countLimiter, concurrency), func(i int) (interface{}, error) {
+			return fn(items[i])
+		})
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[K]V, len(items))
+		for i, v := range values.([]interface{}) {
+			// a nil interface V comes back as a plain nil
+			v, _ := v.(V)
+			m[items[i]] = v
+		}
+		return m, nil
+	}))
+}
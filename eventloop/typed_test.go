@@ -0,0 +1,34 @@
+package eventloop_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alob-mtc/go-promise/eventloop"
+)
+
+func TestAwaitValue(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	p := e.Async(func() (interface{}, error) {
+		return "forty-two", nil
+	})
+	if v, ok, err := eventloop.AwaitValue[string](e, p); !ok || err != nil || v != "forty-two" {
+		t.Fatalf("AwaitValue[string] = %q, %v, %v", v, ok, err)
+	}
+	v, ok, err := eventloop.AwaitValue[int](e, p)
+	if ok || v != 0 {
+		t.Fatalf("AwaitValue[int] = %d, %v, want 0, false", v, ok)
+	}
+	if !errors.Is(err, eventloop.ErrTypeMismatch) || !strings.Contains(err.Error(), "want int, got string") {
+		t.Fatalf("AwaitValue[int] error = %v", err)
+	}
+}
+
+func TestAwaitValueRejection(t *testing.T) {
+	e := eventloop.NewEventLoopWithCapacity(0)
+	p := e.RejectedChain(errBoom)
+	if _, ok, err := eventloop.AwaitValue[int](e, p); ok || err != errBoom {
+		t.Fatalf("AwaitValue[int] = %v, %v, want false, %v", ok, err, errBoom)
+	}
+}
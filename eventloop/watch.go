@@ -0,0 +1,53 @@
+package eventloop
+
+import (
+	"os"
+	"time"
+)
+
+// WatchPollInterval is how often WatchFile checks the file.
+var WatchPollInterval = 100 * time.Millisecond
+
+// WatchFile signals the returned future with the file's os.FileInfo each time
+// the file at path changes size or modification time, including when it
+// appears, recorded whether or not a complete function is registered. If it
+// can no longer be read, the stat error is signalled once as an error event.
+// Changes are found by polling every WatchPollInterval, so edits closer
+// together than that may be reported once. The returned function stops
+// watching and closes the future, as does f.Close; it is safe to call more
+// than once.
+func (e *EventLoop) WatchFile(path string) (*Future, func()) {
+	f := e.NewFuture()
+	stop := make(chan struct{})
+	f.mu.Lock()
+	f.teardown = func() {
+		close(stop)
+	}
+	f.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(WatchPollInterval)
+		defer ticker.Stop()
+		last, lastErr := os.Stat(path)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			info, err := os.Stat(path)
+			switch {
+			case err != nil:
+				if lastErr == nil {
+					f.RejectFuture(err)
+				}
+			case lastErr != nil || info.Size() != last.Size() || !info.ModTime().Equal(last.ModTime()):
+				handler, listeners := f.snapshot()
+				f.emit(handler, listeners, info)
+			}
+			last, lastErr = info, err
+		}
+	}()
+
+	return f, f.Close
+}
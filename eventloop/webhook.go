@@ -0,0 +1,65 @@
+package eventloop
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type webhookPayload struct {
+	ID      uint64      `json:"id"`
+	Name    string      `json:"name,omitempty"`
+	Status  string      `json:"status"`
+	Value   interface{} `json:"value,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	Elapsed string      `json:"elapsed"`
+}
+
+// NotifyWebhook POSTs p's outcome to url as JSON once p settles, e.g.
+//
+//	{"id":3,"name":"export","status":"fulfilled","value":42,"elapsed":"1.2s"}
+//
+// and then passes the outcome on unchanged, so it can sit anywhere in a
+// chain. Elapsed is measured from p's creation. The call is best effort: a
+// value that cannot be encoded, a failed request or a non-2xx response is
+// logged and does not affect the chain. The chain waits for the call, which
+// is bounded by p's context and client's Timeout; a nil client means
+// http.DefaultClient.
+func (p *Promise) NotifyWebhook(url string, client *http.Client) *Promise {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return p.derive(func(value interface{}, err error) (interface{}, error) {
+		payload := webhookPayload{ID: p.id, Name: p.name, Status: Fulfilled.String(), Value: value, Elapsed: time.Since(p.created).String()}
+		if err != nil {
+			payload.Status, payload.Error = Rejected.String(), err.Error()
+		}
+		if notifyErr := postWebhook(p, url, client, payload); notifyErr != nil {
+			p.loop.errorf("eventloop: %s: webhook %s: %v", p.ref(), url, notifyErr)
+		}
+		return value, err
+	})
+}
+
+func postWebhook(p *Promise, url string, client *http.Client, payload webhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(p.context(), http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}